@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"math"
+	"math/big"
+)
+
+// maxSafeInteger — 2^53: начиная с него float64 передаёт целые неточно, и 2^53 + 1 приходит как 2^53.
+const maxSafeInteger = 1 << 53
+
+// calcBitwise считает побитовый оператор или сдвиг в режиме float64. Оркестратор пропускает к ним только целые
+// операнды, но float64 может прийти и дробным, и бесконечным — это DomainErrorCode. Операнд от 2^53 по модулю,
+// посчитанный другой задачей, как в (2^53 + 1) & 1, — OverflowCode: его младшие биты уже потеряны; величины сдвига
+// это не касается. Считается через big.Int, как и в точных режимах, а результат, который float64 не передаёт точно
+// ((1 << 60) | 1), — тоже OverflowCode.
+func (a *Agent) calcBitwise(task *backend.Task) (agentResult backend.AgentResult, err error) {
+	agentResult = backend.AgentResult{ID: task.PairID}
+	var args []*big.Int
+	for i, rawArg := range getTaskArgs(task) {
+		value, isNumber := rawArg.(float64)
+		if !isNumber {
+			agentResult.Error = getCalcError(backend.UnknownOperationCode)
+			return agentResult, errors.New("неизвестная операция или некорректные аргументы")
+		}
+		if math.IsInf(value, 0) || value != math.Trunc(value) {
+			agentResult.Error = getCalcError(backend.DomainErrorCode)
+			return
+		}
+		var isShiftCount = i == 1 && (task.Operation == pkg.ShiftLeft || task.Operation == pkg.ShiftRight)
+		if !isShiftCount && math.Abs(value) >= maxSafeInteger {
+			agentResult.Error = getCalcError(backend.OverflowCode)
+			return
+		}
+		integer, _ := big.NewFloat(value).Int(nil)
+		args = append(args, integer)
+	}
+	result, code := calcInteger(task.Operation, args)
+	if code == "" {
+		var accuracy big.Accuracy
+		if agentResult.Result, accuracy = new(big.Float).SetInt(result).Float64(); accuracy != big.Exact {
+			code = backend.OverflowCode
+		}
+	}
+	if code != "" {
+		agentResult.Result = 0
+		agentResult.Error = getCalcError(code)
+	}
+	return
+}
+
+// calcInteger считает побитовый оператор над целыми. Отрицательные числа ведут себя как в дополнительном коде
+// бесконечной разрядности: ~x = -x - 1, а сдвиг вправо округляет вниз (-1 >> 1 = -1). Отрицательный сдвиг —
+// DomainErrorCode; сдвиг влево, результат которого длиннее maxExactBits, — OverflowCode.
+func calcInteger(operation string, args []*big.Int) (result *big.Int, code backend.ErrorCode) {
+	var a = args[0]
+	if operation == pkg.BitwiseNot {
+		return new(big.Int).Not(a), ""
+	}
+	var b = args[1]
+	switch operation {
+	case pkg.BitwiseAnd:
+		return new(big.Int).And(a, b), ""
+	case pkg.BitwiseOr:
+		return new(big.Int).Or(a, b), ""
+	case pkg.BitwiseXor:
+		return new(big.Int).Xor(a, b), ""
+	}
+	if b.Sign() < 0 {
+		return nil, backend.DomainErrorCode
+	}
+	switch operation {
+	case pkg.ShiftLeft:
+		if a.Sign() == 0 {
+			return new(big.Int), ""
+		}
+		if !b.IsInt64() || b.Int64() > maxExactBits-int64(a.BitLen()) {
+			return nil, backend.OverflowCode
+		}
+		return new(big.Int).Lsh(a, uint(b.Int64())), ""
+	case pkg.ShiftRight: // сдвиг на всю длину числа и дальше даёт 0 или -1.
+		var shift = uint(a.BitLen())
+		if b.IsInt64() && b.Int64() < int64(shift) {
+			shift = uint(b.Int64())
+		}
+		return new(big.Int).Rsh(a, shift), ""
+	}
+	return nil, backend.UnknownOperationCode
+}
+
+// calcIntegerRat — calcInteger для аргументов точных режимов. Дробный аргумент — DomainErrorCode.
+func calcIntegerRat(operation string, args []*big.Rat) (*big.Rat, backend.ErrorCode) {
+	var integers []*big.Int
+	for _, arg := range args {
+		if !arg.IsInt() {
+			return nil, backend.DomainErrorCode
+		}
+		integers = append(integers, arg.Num())
+	}
+	result, code := calcInteger(operation, integers)
+	if code != "" {
+		return nil, code
+	}
+	return new(big.Rat).SetInt(result), ""
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"math"
+	"math/cmplx"
+)
+
+// maxExactPower — наибольший целый показатель, для которого степень считается умножениями, а не через cmplx.Pow:
+// cmplx.Pow идёт через логарифм, и i^2 получилось бы -1+1.2e-16i, а не ровно -1.
+const maxExactPower = 64
+
+// complexFunctions — реализации функций режима complex; набор совпадает с тем, что разрешает
+// pkg.Precision.SupportsOperation. Функции, не определённые для аргументов, возвращают NaN, и calcComplex сообщает
+// DomainErrorCode.
+var complexFunctions = map[string]func(args []complex128) complex128{
+	"abs":   complexUnaryFunction(func(x complex128) complex128 { return complex(cmplx.Abs(x), 0) }),
+	"arg":   complexUnaryFunction(func(x complex128) complex128 { return complex(cmplx.Phase(x), 0) }),
+	"conj":  complexUnaryFunction(cmplx.Conj),
+	"re":    complexUnaryFunction(func(x complex128) complex128 { return complex(real(x), 0) }),
+	"im":    complexUnaryFunction(func(x complex128) complex128 { return complex(imag(x), 0) }),
+	"sqrt":  complexUnaryFunction(cmplx.Sqrt),
+	"exp":   complexUnaryFunction(cmplx.Exp),
+	"log":   complexLogFunction,
+	"log2":  complexUnaryFunction(complexLogarithm(func(x complex128) complex128 { return cmplx.Log(x) / math.Ln2 })),
+	"log10": complexUnaryFunction(complexLogarithm(cmplx.Log10)),
+	"sin":   complexUnaryFunction(cmplx.Sin),
+	"cos":   complexUnaryFunction(cmplx.Cos),
+	"tan":   complexUnaryFunction(cmplx.Tan),
+	"asin":  complexUnaryFunction(cmplx.Asin),
+	"acos":  complexUnaryFunction(cmplx.Acos),
+	"atan":  complexUnaryFunction(cmplx.Atan),
+}
+
+// calcComplex считает задачу режима complex. Аргументы — complex128, результат записывается парой в
+// agentResult.Complex, а его вещественная часть — в agentResult.Result. Ошибки — как у calc.
+func (a *Agent) calcComplex(task *backend.Task) (agentResult backend.AgentResult, err error) {
+	agentResult = backend.AgentResult{ID: task.PairID}
+	args, ok := parseComplexArgs(getTaskArgs(task))
+	if !ok || !task.Precision.SupportsOperation(task.Operation) {
+		agentResult.Error = getCalcError(backend.UnknownOperationCode)
+		return agentResult, errors.New("неизвестная операция или некорректные аргументы")
+	}
+	result, code := calcComplex128(task.Operation, args)
+	switch {
+	case code != "":
+	case cmplx.IsNaN(result):
+		code = backend.DomainErrorCode
+	case cmplx.IsInf(result):
+		code = backend.OverflowCode
+	}
+	if code != "" {
+		agentResult.Error = getCalcError(code)
+		return
+	}
+	var value = backend.ComplexFabric(result)
+	agentResult.Complex, agentResult.Result = &value, real(result)
+	return
+}
+
+func calcComplex128(operation string, args []complex128) (result complex128, code backend.ErrorCode) {
+	if function, ok := complexFunctions[operation]; ok {
+		arity, _ := pkg.GetFunctionArity(operation)
+		if !arity.Accepts(len(args)) {
+			return 0, backend.UnknownOperationCode
+		}
+		return function(args), ""
+	}
+	var a = args[0]
+	switch operation {
+	case pkg.UnaryMinus:
+		return -a, ""
+	case pkg.UnaryPlus:
+		return a, ""
+	}
+	var b = args[1]
+	switch operation {
+	case "+":
+		return a + b, ""
+	case "-":
+		return a - b, ""
+	case "*":
+		return a * b, ""
+	case "/":
+		if b == 0 {
+			return 0, backend.DivisionByZeroCode
+		}
+		return a / b, ""
+	case pkg.Power:
+		return powComplex(a, b)
+	}
+	return 0, backend.UnknownOperationCode
+}
+
+// powComplex возводит a в степень b. Ноль в степени с отрицательной вещественной частью — деление на ноль, а с
+// нулевой (0^i) не определён.
+func powComplex(a, b complex128) (complex128, backend.ErrorCode) {
+	if a == 0 && b != 0 {
+		switch {
+		case real(b) < 0:
+			return 0, backend.DivisionByZeroCode
+		case real(b) == 0:
+			return 0, backend.DomainErrorCode
+		}
+	}
+	var exponent = real(b)
+	if imag(b) != 0 || exponent != math.Trunc(exponent) || math.Abs(exponent) > maxExactPower {
+		return cmplx.Pow(a, b), ""
+	}
+	var result complex128 = 1
+	for range int(math.Abs(exponent)) {
+		result *= a
+	}
+	if exponent < 0 {
+		result = 1 / result
+	}
+	return result, ""
+}
+
+func complexUnaryFunction(function func(complex128) complex128) func(args []complex128) complex128 {
+	return func(args []complex128) complex128 {
+		return function(args[0])
+	}
+}
+
+// complexLogarithm доопределяет логарифм в нуле как NaN, как и logarithm для вещественных чисел. Отрицательные и
+// комплексные аргументы допустимы: берётся главное значение.
+func complexLogarithm(function func(complex128) complex128) func(complex128) complex128 {
+	return func(x complex128) complex128 {
+		if x == 0 {
+			return cmplx.NaN()
+		}
+		return function(x)
+	}
+}
+
+// complexLogFunction — log(x) (натуральный) или log(x, base).
+func complexLogFunction(args []complex128) complex128 {
+	var ln = complexLogarithm(cmplx.Log)
+	if len(args) == 1 {
+		return ln(args[0])
+	}
+	if args[1] == 1 {
+		return cmplx.NaN()
+	}
+	return ln(args[0]) / ln(args[1])
+}
+
+// parseComplexArgs проверяет, что все аргументы — complex128 (их так декодирует backend.Task в режиме complex).
+func parseComplexArgs(rawArgs []interface{}) (args []complex128, ok bool) {
+	for _, rawArg := range rawArgs {
+		arg, isComplex := rawArg.(complex128)
+		if !isComplex {
+			return nil, false
+		}
+		args = append(args, arg)
+	}
+	return args, len(args) > 0
+}
@@ -1,6 +1,100 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	AGENT_CONFIG     = "AGENT_CONFIG"
+	ORCHESTRATOR_URL = "ORCHESTRATOR_URL"
+	POLLING_INTERVAL = "POLLING_INTERVAL"
+	COMPUTING_POWER  = "COMPUTING_POWER"
+	REQUEST_TIMEOUT  = "REQUEST_TIMEOUT"
+	SHUTDOWN_TIMEOUT = "SHUTDOWN_TIMEOUT"
+)
+
+// Config — параметры агента. Заполняется LoadConfig из флагов, переменных среды и конфигурационного файла.
+type Config struct {
+	OrchestratorURL string
+	PollingInterval time.Duration // как часто агент запрашивает у оркестратора новую задачу.
+	ComputingPower  int           // число горутин, которые одновременно считают задачи.
+	RequestTimeout  time.Duration // время на один HTTP-запрос к оркестратору.
+	ShutdownTimeout time.Duration // сколько после сигнала остановки ждать, пока досчитаются полученные задачи.
+}
+
+func ConfigDefaultFabric() *Config {
+	return &Config{
+		OrchestratorURL: "http://localhost:8000",
+		PollingInterval: 30 * time.Millisecond,
+		ComputingPower:  1,
+		RequestTimeout:  5 * time.Second,
+		ShutdownTimeout: 10 * time.Second,
+	}
+}
+
+func (c *Config) settings() []backend.Setting {
+	return []backend.Setting{
+		backend.StringSetting("orchestrator-url", ORCHESTRATOR_URL, "адрес оркестратора", &c.OrchestratorURL),
+		backend.DurationSetting("polling-interval", POLLING_INTERVAL, "интервал опроса оркестратора",
+			&c.PollingInterval),
+		backend.IntSetting("computing-power", COMPUTING_POWER, "число параллельно считаемых задач",
+			&c.ComputingPower),
+		backend.DurationSetting("request-timeout", REQUEST_TIMEOUT, "время на HTTP-запрос к оркестратору",
+			&c.RequestTimeout),
+		backend.DurationSetting("shutdown-timeout", SHUTDOWN_TIMEOUT,
+			"сколько при остановке ждать, пока досчитаются полученные задачи", &c.ShutdownTimeout),
+	}
+}
+
+// Validate проверяет параметры, чтобы агент не запустился с заведомо неработающей конфигурацией.
+func (c *Config) Validate() error {
+	serverURL, err := url.Parse(c.OrchestratorURL)
+	if err != nil {
+		return errors.Join(errors.New("некорректный orchestrator-url"), err)
+	}
+	if (serverURL.Scheme != "http" && serverURL.Scheme != "https") || serverURL.Host == "" {
+		return fmt.Errorf("orchestrator-url должен иметь вид http://хост:порт, получено %q", c.OrchestratorURL)
+	}
+	if c.PollingInterval <= 0 {
+		return errors.New("polling-interval должен быть положительным")
+	}
+	if c.ComputingPower <= 0 {
+		return errors.New("computing-power должен быть положительным")
+	}
+	if c.RequestTimeout < 0 {
+		return errors.New("request-timeout не может быть отрицательным")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdown-timeout не может быть отрицательным")
+	}
+	return nil
+}
+
+// LoadConfig читает конфигурацию из args (без имени программы), переменных среды и файла, путь к которому задан
+// флагом -config или переменной AGENT_CONFIG. Приоритет: флаг > переменная среды > файл > по умолчанию.
+func LoadConfig(args []string, output io.Writer) (config *Config, err error) {
+	config = ConfigDefaultFabric()
+	err = backend.LoadSettings("agent", config.settings(), AGENT_CONFIG, args, output)
+	if err != nil {
+		return nil, err
+	}
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func AgentFabric(config *Config) *Agent {
+	return &Agent{ServerURL: config.OrchestratorURL, getEndpoint: "/internal/task",
+		sendEndpoint: "/internal/task", client: &http.Client{Timeout: config.RequestTimeout}}
+}
+
 func getDefaultAgent() *Agent {
-	return &Agent{ServerURL: "http://localhost:8000", getEndpoint: "/internal/task",
-		sendEndpoint: "/internal/task"}
+	return AgentFabric(ConfigDefaultFabric())
 }
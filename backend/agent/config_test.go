@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+// clearConfigEnv скрывает от теста переменные среды агента (например, заданные в agent.env).
+func clearConfigEnv(t *testing.T) {
+	t.Setenv(AGENT_CONFIG, "")
+	for _, setting := range ConfigDefaultFabric().settings() {
+		t.Setenv(setting.Env, "")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv(COMPUTING_POWER, "4")
+	t.Setenv(POLLING_INTERVAL, "1s")
+	config, err := LoadConfig([]string{"-polling-interval", "100ms", "-orchestrator-url", "http://10.0.0.1:8000"},
+		io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &Config{OrchestratorURL: "http://10.0.0.1:8000", PollingInterval: 100 * time.Millisecond,
+		ComputingPower: 4, RequestTimeout: ConfigDefaultFabric().RequestTimeout,
+		ShutdownTimeout: ConfigDefaultFabric().ShutdownTimeout}, config)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	var cases = map[string]string{
+		COMPUTING_POWER:  "0",
+		POLLING_INTERVAL: "30",
+		ORCHESTRATOR_URL: "localhost:8000",
+	}
+	for env, value := range cases {
+		t.Run(env, func(t *testing.T) {
+			clearConfigEnv(t)
+			t.Setenv(env, value)
+			config, err := LoadConfig(nil, io.Discard)
+			assert.Error(t, err)
+			assert.Nil(t, config)
+		})
+	}
+}
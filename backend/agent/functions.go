@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// functions — реализации встроенных функций из pkg. Число аргументов проверяется в calc по pkg.GetFunctionArity.
+// Функции, не определённые для аргументов, возвращают NaN, и calc сообщает оркестратору DomainErrorCode.
+var functions = map[string]func(args []float64) float64{
+	"abs":   unaryFunction(math.Abs),
+	"sqrt":  unaryFunction(math.Sqrt),
+	"cbrt":  unaryFunction(math.Cbrt),
+	"exp":   unaryFunction(math.Exp),
+	"log":   logFunction,
+	"log2":  unaryFunction(logarithm(math.Log2)),
+	"log10": unaryFunction(logarithm(math.Log10)),
+	"sin":   unaryFunction(math.Sin),
+	"cos":   unaryFunction(math.Cos),
+	"tan":   unaryFunction(math.Tan),
+	"asin":  unaryFunction(math.Asin),
+	"acos":  unaryFunction(math.Acos),
+	"atan":  unaryFunction(math.Atan),
+	"atan2": func(args []float64) float64 {
+		return math.Atan2(args[0], args[1])
+	},
+	"round": unaryFunction(math.Round),
+	"floor": unaryFunction(math.Floor),
+	"ceil":  unaryFunction(math.Ceil),
+	"trunc": unaryFunction(math.Trunc),
+	"min":   slices.Min[[]float64],
+	"max":   slices.Max[[]float64],
+}
+
+func unaryFunction(function func(float64) float64) func(args []float64) float64 {
+	return func(args []float64) float64 {
+		return function(args[0])
+	}
+}
+
+// logarithm доопределяет логарифм в нуле как NaN: math.Log(0) = -Inf, и иначе агент сообщил бы о переполнении,
+// а не о выходе из области определения.
+func logarithm(function func(float64) float64) func(float64) float64 {
+	return func(x float64) float64 {
+		if x <= 0 {
+			return math.NaN()
+		}
+		return function(x)
+	}
+}
+
+// logFunction — log(x) (натуральный) или log(x, base).
+func logFunction(args []float64) float64 {
+	var ln = logarithm(math.Log)
+	if len(args) == 1 {
+		return ln(args[0])
+	}
+	if args[1] == 1 {
+		return math.NaN()
+	}
+	return ln(args[0]) / ln(args[1])
+}
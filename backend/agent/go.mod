@@ -4,4 +4,13 @@ go 1.24.0
 
 replace github.com/Debianov/calc-ya-go-24 v0.0.0-20250302045807-432e7a102e57 => ../..
 
-require github.com/Debianov/calc-ya-go-24 v0.0.0-20250302045807-432e7a102e57
+require (
+	github.com/Debianov/calc-ya-go-24 v0.0.0-20250302045807-432e7a102e57
+	github.com/stretchr/testify v1.10.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
@@ -6,8 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Debianov/calc-ya-go-24/backend"
-	"io"
-	"log"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"math"
 	"net/http"
 )
 
@@ -16,37 +16,44 @@ type Agent struct {
 	// горутин не требуется.
 	getEndpoint  string
 	sendEndpoint string
+	client       *http.Client
 }
 
-func (a *Agent) get() (result *backend.Task, ok bool) {
-	var err error
-	resp, err := http.Get(a.ServerURL + a.getEndpoint)
+// get запрашивает у оркестратора задачу. ok == false без ошибки означает, что готовых задач нет.
+func (a *Agent) get() (result *backend.Task, ok bool, err error) {
+	resp, err := a.client.Get(a.ServerURL + a.getEndpoint)
 	if err != nil {
-		log.Panic(err)
+		return
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		ok = false
 		return
-	} else {
-		ok = true
-	}
-	var (
-		reqBuf = make([]byte, resp.ContentLength)
-	)
-	_, err = resp.Body.Read(reqBuf)
-	if err != nil && err != io.EOF {
-		log.Panic(err)
 	}
 	var structInResp backend.TaskToSend
-	err = json.Unmarshal(reqBuf, &structInResp)
+	err = json.NewDecoder(resp.Body).Decode(&structInResp)
 	if err != nil {
-		log.Panic(err)
+		return
 	}
-	result = structInResp.Task
+	result, ok = structInResp.Task, true
 	return
 }
 
-func (a *Agent) calc(task backend.Task) (agentResult backend.AgentResult, err error) {
+// calc считает задачу: оператор над Arg1 и Arg2 или функцию над Args. Арифметические ошибки (деление на ноль,
+// переполнение, выход из области определения) не возвращаются как err, а записываются в agentResult.Error, чтобы
+// оркестратор узнал причину. err возвращается только для неизвестной операции. Задачи точных режимов считает
+// calcExact, режима complex — calcComplex, сравнения и логические операторы — calcLogical, побитовые операторы —
+// calcBitwise.
+func (a *Agent) calc(task *backend.Task) (agentResult backend.AgentResult, err error) {
+	switch {
+	case task.Precision.IsExact():
+		return a.calcExact(task)
+	case task.Precision == pkg.Complex:
+		return a.calcComplex(task)
+	case pkg.ReturnsBoolean(task.Operation):
+		return a.calcLogical(task)
+	case pkg.IsBitwise(task.Operation):
+		return a.calcBitwise(task)
+	}
 	var result float64
 	agentResult = backend.AgentResult{
 		ID: task.PairID,
@@ -59,24 +66,99 @@ func (a *Agent) calc(task backend.Task) (agentResult backend.AgentResult, err er
 	case "*":
 		result = task.Arg1.(float64) * task.Arg2.(float64)
 	case "/":
+		if task.Arg2.(float64) == 0 {
+			agentResult.Error = getCalcError(backend.DivisionByZeroCode)
+			return
+		}
 		result = task.Arg1.(float64) / task.Arg2.(float64)
+	case pkg.Modulo, pkg.IntDivision:
+		if task.Arg2.(float64) == 0 {
+			agentResult.Error = getCalcError(backend.DivisionByZeroCode)
+			return
+		}
+		if task.Operation == pkg.Modulo {
+			result = floorMod(task.Arg1.(float64), task.Arg2.(float64))
+		} else {
+			result = math.Floor(task.Arg1.(float64) / task.Arg2.(float64))
+		}
+	case pkg.Power:
+		if task.Arg1.(float64) == 0 && task.Arg2.(float64) < 0 { // 0^-n = 1/0^n.
+			agentResult.Error = getCalcError(backend.DivisionByZeroCode)
+			return
+		}
+		result = math.Pow(task.Arg1.(float64), task.Arg2.(float64))
+	case pkg.UnaryMinus:
+		result = -task.Arg1.(float64)
+	case pkg.UnaryPlus:
+		result = task.Arg1.(float64)
 	default:
-		err = errors.New("неизвестная операция")
+		var (
+			function, ok = functions[task.Operation]
+			args         []float64
+		)
+		if ok {
+			args, ok = getFunctionArgs(task.Operation, task.Args)
+		}
+		if !ok {
+			agentResult.Error = getCalcError(backend.UnknownOperationCode)
+			err = errors.New("неизвестная операция")
+			return
+		}
+		result = function(args)
+	}
+	if math.IsNaN(result) {
+		agentResult.Error = getCalcError(backend.DomainErrorCode)
+		return
+	}
+	if math.IsInf(result, 0) {
+		agentResult.Error = getCalcError(backend.OverflowCode)
 		return
 	}
-	agentResult.Result = int64(result)
+	agentResult.Result = result
 	return
 }
 
+// floorMod — остаток от деления с округлением частного вниз: знак результата совпадает со знаком b. math.Mod
+// округляет частное к нулю, поэтому для операндов разных знаков к его остатку прибавляется b.
+func floorMod(a, b float64) float64 {
+	var result = math.Mod(a, b)
+	if result != 0 && (result < 0) != (b < 0) {
+		result += b
+	}
+	return result
+}
+
+// getFunctionArgs достаёт аргументы вызова функции. ok == false, если их число не подходит функции.
+func getFunctionArgs(function string, rawArgs []interface{}) (args []float64, ok bool) {
+	arity, ok := pkg.GetFunctionArity(function)
+	if !ok || !arity.Accepts(len(rawArgs)) {
+		return nil, false
+	}
+	for _, arg := range rawArgs {
+		value, isNumber := arg.(float64)
+		if !isNumber {
+			return nil, false
+		}
+		args = append(args, value)
+	}
+	return args, true
+}
+
+func getCalcError(code backend.ErrorCode) *backend.ErrorDetails {
+	var details = backend.ErrorDetailsFabric(code)
+	return &details
+}
+
 func (a *Agent) send(agentResult backend.AgentResult) (err error) {
 	reqBuf, err := json.Marshal(agentResult)
 	if err != nil {
 		return
 	}
-	resp, err := http.Post(a.ServerURL+a.sendEndpoint, "application/json", bytes.NewReader(reqBuf))
+	resp, err := a.client.Post(a.ServerURL+a.sendEndpoint, "application/json", bytes.NewReader(reqBuf))
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		err = fmt.Errorf("результат ID %d не записан, код: %d", agentResult.ID, resp.StatusCode)
 		return
@@ -1 +1,390 @@
 package main
+
+import (
+	"encoding/json"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestAgentCalcUnary(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			arg1      float64
+			expected  float64
+		}{{"neg", 3, -3}, {"neg", -4, 4}, {"pos", 5, 5}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 0, Arg1: testCase.arg1, Operation: testCase.operation}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, agentResult.Result)
+	}
+}
+
+func TestAgentCalcBinary(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			arg1      float64
+			arg2      float64
+			expected  float64
+		}{{"+", 1.5, 2, 3.5}, {"-", 2, 0.25, 1.75}, {"*", 1.5, 1.5, 2.25}, {"/", 7, 2, 3.5},
+			{"^", 2, 10, 1024}, {"^", 2, -2, 0.25}, {"^", 9, 0.5, 3}, {"^", -8, 3, -512}, {"^", 0, 0, 1},
+			{"%", 7, 3, 1}, {"%", -7, 3, 2}, {"%", 7, -3, -2}, {"%", -7, -3, -1}, {"%", 7.5, 2, 1.5}, {"%", 6, 3, 0},
+			{"//", 7, 2, 3}, {"//", -7, 2, -4}, {"//", 7, -2, -4}, {"//", -7, -2, 3}, {"//", 7.5, 0.5, 15}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 0, Arg1: testCase.arg1, Arg2: testCase.arg2, Operation: testCase.operation}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, agentResult.Result)
+	}
+}
+
+func TestAgentCalcErrors(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			arg1      float64
+			arg2      float64
+			expected  backend.ErrorCode
+		}{{"/", 5, 0, backend.DivisionByZeroCode}, {"/", 0, 0, backend.DivisionByZeroCode},
+			{"*", 1e308, 10, backend.OverflowCode}, {"-", -1e308, 1e308, backend.OverflowCode},
+			{"^", 10, 400, backend.OverflowCode}, {"^", -8, 1.0 / 3, backend.DomainErrorCode},
+			{"^", 0, -1, backend.DivisionByZeroCode}, {"%", 5, 0, backend.DivisionByZeroCode},
+			{"//", 5, 0, backend.DivisionByZeroCode}, {"//", 1e308, 1e-10, backend.OverflowCode},
+			{"%", math.Inf(1), 2, backend.DomainErrorCode}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 3, Arg1: testCase.arg1, Arg2: testCase.arg2, Operation: testCase.operation}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		if assert.NotNil(t, agentResult.Error) {
+			assert.Equal(t, testCase.expected, agentResult.Error.Code)
+		}
+		assert.Equal(t, 3, agentResult.ID)
+	}
+}
+
+func TestAgentCalcUnknownOperation(t *testing.T) {
+	var task = backend.Task{PairID: 0, Arg1: float64(1), Arg2: float64(2), Operation: "?"}
+	agentResult, err := getDefaultAgent().calc(&task)
+	assert.Error(t, err)
+	if assert.NotNil(t, agentResult.Error) {
+		assert.Equal(t, backend.UnknownOperationCode, agentResult.Error.Code)
+	}
+}
+
+func TestAgentCalcFunctions(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			args      []interface{}
+			expected  float64
+		}{{"sqrt", []interface{}{16.0}, 4}, {"abs", []interface{}{-2.5}, 2.5},
+			{"min", []interface{}{3.0, -1.0, 2.0}, -1}, {"max", []interface{}{3.0, -1.0, 2.0}, 3},
+			{"round", []interface{}{2.5}, 3}, {"floor", []interface{}{-1.5}, -2}, {"ceil", []interface{}{1.2}, 2},
+			{"log", []interface{}{8.0, 2.0}, 3}, {"log10", []interface{}{1000.0}, 3}, {"exp", []interface{}{0.0}, 1},
+			{"cos", []interface{}{0.0}, 1}, {"atan2", []interface{}{0.0, 1.0}, 0}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 1, Args: testCase.args, Operation: testCase.operation}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		assert.Nil(t, agentResult.Error)
+		assert.InDelta(t, testCase.expected, agentResult.Result, 1e-12, testCase.operation)
+	}
+}
+
+func TestAgentCalcFunctionErrors(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			args      []interface{}
+			expected  backend.ErrorCode
+		}{{"sqrt", []interface{}{-1.0}, backend.DomainErrorCode},
+			{"log", []interface{}{0.0}, backend.DomainErrorCode},
+			{"log", []interface{}{2.0, 1.0}, backend.DomainErrorCode},
+			{"asin", []interface{}{2.0}, backend.DomainErrorCode},
+			{"exp", []interface{}{1000.0}, backend.OverflowCode},
+			{"atan2", []interface{}{1.0}, backend.UnknownOperationCode}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 1, Args: testCase.args, Operation: testCase.operation}
+		agentResult, _ := agent.calc(&task)
+		if assert.NotNil(t, agentResult.Error, testCase.operation) {
+			assert.Equal(t, testCase.expected, agentResult.Error.Code, testCase.operation)
+		}
+	}
+}
+
+func TestAgentCalcExact(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			precision pkg.Precision
+			operation string
+			args      []interface{}
+			expected  string
+		}{{pkg.Big, "+", []interface{}{"1/10", "1/5"}, "3/10"}, {pkg.Big, "/", []interface{}{"1", "3"}, "1/3"},
+			{pkg.Big, "^", []interface{}{"2/3", "-2"}, "9/4"}, {pkg.Big, "%", []interface{}{"-7", "3"}, "2"},
+			{pkg.Big, "//", []interface{}{"7/2", "1"}, "3"}, {pkg.Big, "neg", []interface{}{"1/2"}, "-1/2"},
+			{pkg.Big, "round", []interface{}{"-5/2"}, "-3"}, {pkg.Big, "ceil", []interface{}{"1/3"}, "1"},
+			{pkg.Big, "sqrt", []interface{}{"9/4"}, "3/2"}, {pkg.Big, "max", []interface{}{"1/3", "1/2", "0"}, "1/2"},
+			{pkg.Int64, "*", []interface{}{"3037000499", "3037000499"}, "9223372030926249001"},
+			{pkg.Int64, "/", []interface{}{"-12", "4"}, "-3"}, {pkg.Int64, "//", []interface{}{"-7", "2"}, "-4"},
+			{pkg.Int64, "^", []interface{}{"-1", "-3"}, "-1"}, {pkg.Int64, "^", []interface{}{"2", "62"},
+				"4611686018427387904"}, {pkg.Int64, "trunc", []interface{}{"5"}, "5"}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 2, Operation: testCase.operation, Precision: testCase.precision}
+		switch len(testCase.args) {
+		case 1:
+			task.Arg1 = testCase.args[0]
+		case 2:
+			task.Arg1, task.Arg2 = testCase.args[0], testCase.args[1]
+		}
+		if !pkg.IsOperator(task.Operation) && !pkg.IsUnaryOperator(task.Operation) {
+			task.Args, task.Arg1, task.Arg2 = testCase.args, nil, nil
+		}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		assert.Nil(t, agentResult.Error, testCase.operation)
+		assert.Equal(t, testCase.expected, agentResult.Value, testCase.operation)
+	}
+}
+
+func TestAgentCalcExactErrors(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			precision pkg.Precision
+			operation string
+			arg1      string
+			arg2      string
+			expected  backend.ErrorCode
+		}{{pkg.Int64, "+", "9223372036854775807", "1", backend.OverflowCode},
+			{pkg.Int64, "^", "2", "63", backend.OverflowCode}, {pkg.Int64, "/", "7", "2", backend.DomainErrorCode},
+			{pkg.Int64, "^", "2", "-1", backend.DomainErrorCode}, {pkg.Int64, "%", "1", "0", backend.DivisionByZeroCode},
+			{pkg.Big, "/", "1", "0", backend.DivisionByZeroCode}, {pkg.Big, "^", "2", "1/2", backend.DomainErrorCode},
+			{pkg.Big, "^", "0", "-1", backend.DivisionByZeroCode}, {pkg.Big, "^", "10", "1000000000", backend.OverflowCode},
+			{pkg.Int64, "+", "1/2", "1", backend.UnknownOperationCode}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 2, Arg1: testCase.arg1, Arg2: testCase.arg2, Operation: testCase.operation,
+			Precision: testCase.precision}
+		agentResult, _ := agent.calc(&task)
+		if assert.NotNil(t, agentResult.Error, testCase.operation) {
+			assert.Equal(t, testCase.expected, agentResult.Error.Code, testCase.operation)
+		}
+	}
+}
+
+func TestAgentCalcRational(t *testing.T) {
+	var task backend.Task
+	err := json.Unmarshal([]byte(`{"id": 4, "arg1": {"num": "1", "den": "3"}, "arg2": {"num": "2", "den": "12"},
+		"operation": "+", "precision": "rational"}`), &task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "1/3", task.Arg1)
+	assert.Equal(t, "1/6", task.Arg2)
+
+	agentResult, err := getDefaultAgent().calc(&task)
+	assert.NoError(t, err)
+	assert.Nil(t, agentResult.Error)
+	assert.Equal(t, &backend.Fraction{Num: "1", Den: "2"}, agentResult.Fraction)
+	assert.Empty(t, agentResult.Value)
+	assert.Equal(t, 0.5, agentResult.Result)
+
+	err = json.Unmarshal([]byte(`{"id": 4, "arg1": {"num": "1", "den": "0"}, "arg2": {"num": "1", "den": "1"},
+		"operation": "+", "precision": "rational"}`), &task)
+	assert.Error(t, err)
+}
+
+func TestAgentCalcComplex(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			args      []complex128
+			expected  complex128
+		}{{"+", []complex128{3, 4i}, 3 + 4i}, {"*", []complex128{1 + 2i, 3 - 1i}, 5 + 5i},
+			{"/", []complex128{1, 1i}, -1i}, {"^", []complex128{1i, 2}, -1}, {"^", []complex128{1 + 1i, -2}, -0.5i},
+			{"neg", []complex128{2 - 3i}, -2 + 3i}, {"sqrt", []complex128{-4}, 2i}, {"abs", []complex128{3 + 4i}, 5},
+			{"arg", []complex128{1i}, math.Pi / 2}, {"conj", []complex128{1 + 2i}, 1 - 2i},
+			{"re", []complex128{1 + 2i}, 1}, {"im", []complex128{1 + 2i}, 2},
+			{"exp", []complex128{complex(0, math.Pi)}, -1}, {"log", []complex128{-1}, complex(0, math.Pi)}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 5, Operation: testCase.operation, Precision: pkg.Complex}
+		var args []interface{}
+		for _, arg := range testCase.args {
+			args = append(args, arg)
+		}
+		switch {
+		case !pkg.IsOperator(task.Operation) && !pkg.IsUnaryOperator(task.Operation):
+			task.Args = args
+		case len(args) == 1:
+			task.Arg1 = args[0]
+		default:
+			task.Arg1, task.Arg2 = args[0], args[1]
+		}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		if assert.Nil(t, agentResult.Error, testCase.operation) && assert.NotNil(t, agentResult.Complex) {
+			assert.InDelta(t, real(testCase.expected), agentResult.Complex.Re, 1e-12, testCase.operation)
+			assert.InDelta(t, imag(testCase.expected), agentResult.Complex.Im, 1e-12, testCase.operation)
+			assert.Equal(t, agentResult.Complex.Re, agentResult.Result)
+		}
+	}
+}
+
+func TestAgentCalcComplexErrors(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			arg1      complex128
+			arg2      complex128
+			expected  backend.ErrorCode
+		}{{"/", 1i, 0, backend.DivisionByZeroCode}, {"^", 0, -1 + 1i, backend.DivisionByZeroCode},
+			{"^", 0, 1i, backend.DomainErrorCode}, {"*", 1e308 + 1e308i, 10, backend.OverflowCode},
+			{"%", 5, 2, backend.UnknownOperationCode}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 5, Arg1: testCase.arg1, Arg2: testCase.arg2, Operation: testCase.operation,
+			Precision: pkg.Complex}
+		agentResult, _ := agent.calc(&task)
+		if assert.NotNil(t, agentResult.Error, testCase.operation) {
+			assert.Equal(t, testCase.expected, agentResult.Error.Code, testCase.operation)
+		}
+	}
+
+	var task backend.Task
+	err := json.Unmarshal([]byte(`{"id": 5, "args": [{"re": 0, "im": -1}], "operation": "log",
+		"precision": "complex"}`), &task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentResult, err := agent.calc(&task)
+	assert.NoError(t, err)
+	assert.Equal(t, &backend.Complex{Re: 0, Im: -math.Pi / 2}, agentResult.Complex)
+	task.Args = []interface{}{complex128(0)}
+	agentResult, _ = agent.calc(&task)
+	if assert.NotNil(t, agentResult.Error) {
+		assert.Equal(t, backend.DomainErrorCode, agentResult.Error.Code)
+	}
+}
+
+func TestAgentCalcLogical(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			operation string
+			arg1      interface{}
+			arg2      interface{}
+			expected  bool
+		}{{"<", 1.0, 2.0, true}, {"<=", 2.0, 2.0, true}, {">", 1.0, 2.0, false}, {">=", 3.0, 2.0, true},
+			{"==", 2.0, 2.0, true}, {"!=", 1.0, 2.0, true}, {"==", true, true, true},
+			{"!=", true, false, true}, {"&&", true, false, false}, {"||", false, true, true},
+			{"!", false, nil, true}, {"<", math.Inf(-1), 0.0, true}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 5, Arg1: testCase.arg1, Arg2: testCase.arg2, Operation: testCase.operation}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		if assert.Nil(t, agentResult.Error, testCase.operation) && assert.NotNil(t, agentResult.Boolean) {
+			assert.Equal(t, testCase.expected, *agentResult.Boolean, testCase.operation)
+		}
+	}
+
+	t.Run("wrong argument types", func(t *testing.T) {
+		for _, task := range []*backend.Task{{Operation: "<", Arg1: true, Arg2: false},
+			{Operation: "&&", Arg1: 1.0, Arg2: true}, {Operation: "==", Arg1: 1.0, Arg2: true},
+			{Operation: "!", Arg1: 0.0}, {Operation: "<", Arg1: "1", Arg2: "2", Precision: pkg.Int64}} {
+			agentResult, err := agent.calc(task)
+			assert.Error(t, err, task.Operation)
+			if assert.NotNil(t, agentResult.Error, task.Operation) {
+				assert.Equal(t, backend.UnknownOperationCode, agentResult.Error.Code)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var task backend.Task
+		if err := json.Unmarshal([]byte(`{"id": 5, "arg1": true, "arg2": false, "operation": "||"}`),
+			&task); err != nil {
+			t.Fatal(err)
+		}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		buf, _ := json.Marshal(agentResult)
+		assert.JSONEq(t, `{"ID": 5, "result": 0, "boolean": true}`, string(buf))
+	})
+}
+
+func TestAgentCalcBitwise(t *testing.T) {
+	var (
+		agent = getDefaultAgent()
+		cases = []struct {
+			precision pkg.Precision
+			operation string
+			arg1      interface{}
+			arg2      interface{}
+			expected  interface{}
+		}{{pkg.Float64, "&", 12.0, 10.0, 8.0}, {pkg.Float64, "|", 12.0, 10.0, 14.0},
+			{pkg.Float64, "xor", 12.0, 10.0, 6.0}, {pkg.Float64, "~", 5.0, nil, -6.0},
+			{pkg.Float64, "<<", 1.0, 10.0, 1024.0}, {pkg.Float64, ">>", -7.0, 1.0, -4.0},
+			{pkg.Float64, ">>", -1.0, 1e300, -1.0}, {pkg.Float64, "&", -1.0, 255.0, 255.0},
+			{pkg.Int64, "<<", "1", "62", "4611686018427387904"}, {pkg.Int64, "xor", "-1", "255", "-256"},
+			{pkg.Int64, "~", "-9223372036854775808", nil, "9223372036854775807"},
+			{pkg.Big, "<<", "1", "64", "18446744073709551616"}, {pkg.Big, ">>", "18446744073709551616", "63", "2"}}
+	)
+	for _, testCase := range cases {
+		var task = backend.Task{PairID: 3, Arg1: testCase.arg1, Arg2: testCase.arg2, Operation: testCase.operation,
+			Precision: testCase.precision}
+		agentResult, err := agent.calc(&task)
+		assert.NoError(t, err)
+		assert.Nil(t, agentResult.Error, testCase.operation)
+		if testCase.precision.IsExact() {
+			assert.Equal(t, testCase.expected, agentResult.Value, testCase.operation)
+		} else {
+			assert.Equal(t, testCase.expected, agentResult.Result, testCase.operation)
+		}
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		var cases = []struct {
+			precision pkg.Precision
+			operation string
+			arg1      interface{}
+			arg2      interface{}
+			expected  backend.ErrorCode
+		}{{pkg.Float64, "&", 1.5, 1.0, backend.DomainErrorCode}, {pkg.Float64, "|", math.Inf(1), 1.0,
+			backend.DomainErrorCode}, {pkg.Float64, "<<", 1.0, -1.0, backend.DomainErrorCode},
+			{pkg.Float64, "<<", 1.0, 1024.0, backend.OverflowCode},
+			{pkg.Float64, "|", math.Pow(2, 60), 1.0, backend.OverflowCode},
+			{pkg.Float64, "&", math.Pow(2, 53) + 1, 1.0, backend.OverflowCode},
+			{pkg.Int64, "<<", "1", "63", backend.OverflowCode}, {pkg.Big, ">>", "1", "-2", backend.DomainErrorCode},
+			{pkg.Big, "<<", "3", "1000000000", backend.OverflowCode}, {pkg.Big, "&", "1/2", "1",
+				backend.DomainErrorCode}, {pkg.Float64, "&", true, 1.0, backend.UnknownOperationCode}}
+		for _, testCase := range cases {
+			var task = backend.Task{PairID: 3, Arg1: testCase.arg1, Arg2: testCase.arg2,
+				Operation: testCase.operation, Precision: testCase.precision}
+			agentResult, _ := agent.calc(&task)
+			if assert.NotNil(t, agentResult.Error, testCase.operation) {
+				assert.Equal(t, testCase.expected, agentResult.Error.Code, testCase.operation)
+			}
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+)
+
+// calcLogical считает сравнение или логический оператор. Аргументы сравнений — числа (== и != сравнивают и
+// логические значения), логических операторов — bool. Результат записывается в agentResult.Boolean. Ошибки — как у
+// calc, хотя арифметических ошибок здесь не бывает.
+func (a *Agent) calcLogical(task *backend.Task) (agentResult backend.AgentResult, err error) {
+	agentResult = backend.AgentResult{ID: task.PairID}
+	result, ok := calcBoolean(task.Operation, getTaskArgs(task))
+	if !ok {
+		agentResult.Error = getCalcError(backend.UnknownOperationCode)
+		return agentResult, errors.New("неизвестная операция или некорректные аргументы")
+	}
+	agentResult.Boolean = &result
+	return
+}
+
+func calcBoolean(operation string, args []interface{}) (result bool, ok bool) {
+	if operation == pkg.LogicalNot {
+		value, ok := args[0].(bool)
+		return !value, ok
+	}
+	switch left := args[0].(type) {
+	case bool:
+		right, isBool := args[1].(bool)
+		if !isBool {
+			return false, false
+		}
+		switch operation {
+		case pkg.LogicalAnd:
+			return left && right, true
+		case pkg.LogicalOr:
+			return left || right, true
+		case pkg.Equal:
+			return left == right, true
+		case pkg.NotEqual:
+			return left != right, true
+		}
+	case float64:
+		right, isNumber := args[1].(float64)
+		if !isNumber {
+			return false, false
+		}
+		switch operation {
+		case pkg.Less:
+			return left < right, true
+		case pkg.LessEqual:
+			return left <= right, true
+		case pkg.Greater:
+			return left > right, true
+		case pkg.GreaterEqual:
+			return left >= right, true
+		case pkg.Equal:
+			return left == right, true
+		case pkg.NotEqual:
+			return left != right, true
+		}
+	}
+	return false, false
+}
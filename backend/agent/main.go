@@ -1,70 +1,39 @@
 package main
 
 import (
-	"github.com/Debianov/calc-ya-go-24/backend"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
-	"strconv"
-	"sync"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
 func main() {
-	var (
-		err   error
-		agent = getDefaultAgent()
-		wg    sync.WaitGroup
-	)
-
-	var numberCalcGoroutinesInString = os.Getenv("COMPUTING_POWER")
-	numberCalcGoroutines, err := strconv.ParseInt(numberCalcGoroutinesInString, 10, 32)
-
-	var (
-		results          = make(chan backend.AgentResult, numberCalcGoroutines)
-		tasksReadyToCalc = make(chan backend.Task, numberCalcGoroutines)
-	)
-
-	for range numberCalcGoroutines {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for {
-				select {
-				case task := <-tasksReadyToCalc:
-					agentResult, err := agent.calc(task)
-					if err != nil {
-						log.Println(err, task.PairID)
-					}
-					results <- agentResult
-				}
-			}
-		}()
+	config, err := LoadConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case <-time.After(30 * time.Millisecond):
-				task, ok := agent.get()
-				if ok {
-					tasksReadyToCalc <- *task
-				}
-			}
-		}
-	}()
-	wg.Add(1)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ошибка конфигурации:", err)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var done = make(chan struct{})
 	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case result := <-results:
-				err = agent.send(result)
-				if err != nil {
-					log.Println(err, result.ID)
-				}
-			}
-		}
+		defer close(done)
+		AgentFabric(config).Run(ctx, config.ComputingPower, config.PollingInterval)
 	}()
-	wg.Wait()
+	<-ctx.Done()
+	log.Println("получен сигнал завершения, агент досчитывает полученные задачи")
+	select {
+	case <-done:
+	case <-time.After(config.ShutdownTimeout):
+		log.Printf("не все задачи досчитаны за %s, оркестратор выдаст их повторно", config.ShutdownTimeout)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"math/big"
+	"strconv"
+)
+
+// maxExactBits ограничивает размер числителя и знаменателя при возведении в степень в точных режимах: без него
+// 10^1000000000 заняло бы всю память агента. Больший результат считается переполнением.
+const maxExactBits = 1 << 20
+
+var (
+	one  = big.NewRat(1, 1)
+	half = big.NewRat(1, 2)
+)
+
+// exactFunctions — функции точных режимов. Какие из них доступны в каком режиме, решает pkg.Precision.SupportsOperation.
+var exactFunctions = map[string]func(args []*big.Rat, bits uint) (*big.Rat, backend.ErrorCode){
+	"abs":   exactUnaryFunction(func(x *big.Rat) *big.Rat { return new(big.Rat).Abs(x) }),
+	"floor": exactUnaryFunction(floorRat),
+	"ceil": exactUnaryFunction(func(x *big.Rat) *big.Rat {
+		return new(big.Rat).Neg(floorRat(new(big.Rat).Neg(x)))
+	}),
+	"trunc": exactUnaryFunction(func(x *big.Rat) *big.Rat {
+		return new(big.Rat).SetInt(new(big.Int).Quo(x.Num(), x.Denom()))
+	}),
+	"round": exactUnaryFunction(roundRat),
+	"min": func(args []*big.Rat, _ uint) (*big.Rat, backend.ErrorCode) {
+		return selectRat(args, -1), ""
+	},
+	"max": func(args []*big.Rat, _ uint) (*big.Rat, backend.ErrorCode) {
+		return selectRat(args, 1), ""
+	},
+	"sqrt": func(args []*big.Rat, bits uint) (*big.Rat, backend.ErrorCode) {
+		if args[0].Sign() < 0 {
+			return nil, backend.DomainErrorCode
+		}
+		var root = new(big.Float).SetPrec(bits).SetRat(args[0])
+		result, _ := root.Sqrt(root).Rat(nil)
+		return result, ""
+	},
+}
+
+// calcExact считает задачу точного режима (см. pkg.Precision). Аргументы — строки с точной записью, результат —
+// строка в agentResult.Value или, в rational, пара в agentResult.Fraction; в agentResult.Result записывается
+// приближение результата float64. Все режимы считаются через big.Rat, а в int64 результат дополнительно
+// проверяется: дробный даёт DomainErrorCode, не помещающийся в int64 — OverflowCode.
+func (a *Agent) calcExact(task *backend.Task) (agentResult backend.AgentResult, err error) {
+	agentResult = backend.AgentResult{ID: task.PairID}
+	args, ok := parseExactArgs(task.Precision, getTaskArgs(task))
+	if !ok || !task.Precision.SupportsOperation(task.Operation) {
+		agentResult.Error = getCalcError(backend.UnknownOperationCode)
+		return agentResult, errors.New("неизвестная операция или некорректные аргументы")
+	}
+	var bits = task.Bits
+	if bits == 0 {
+		bits = pkg.DefaultBits
+	}
+	result, code := calcRat(task.Operation, args, bits)
+	if code == "" && task.Precision == pkg.Int64 {
+		code = checkInt64(result)
+	}
+	if code != "" {
+		agentResult.Error = getCalcError(code)
+		return
+	}
+	switch task.Precision {
+	case pkg.Int64:
+		agentResult.Value = result.Num().String()
+	case pkg.Rational:
+		agentResult.Fraction = &backend.Fraction{Num: result.Num().String(), Den: result.Denom().String()}
+	default:
+		agentResult.Value = result.RatString()
+	}
+	agentResult.Result, _ = result.Float64()
+	return
+}
+
+// getTaskArgs возвращает аргументы задачи списком: Args у функции, Arg1 у унарного оператора, Arg1 и Arg2 у
+// бинарного.
+func getTaskArgs(task *backend.Task) []interface{} {
+	switch {
+	case task.IsFunction():
+		return task.Args
+	case task.IsUnary():
+		return []interface{}{task.Arg1}
+	}
+	return []interface{}{task.Arg1, task.Arg2}
+}
+
+func calcRat(operation string, args []*big.Rat, bits uint) (result *big.Rat, code backend.ErrorCode) {
+	if function, ok := exactFunctions[operation]; ok {
+		arity, _ := pkg.GetFunctionArity(operation)
+		if !arity.Accepts(len(args)) {
+			return nil, backend.UnknownOperationCode
+		}
+		return function(args, bits)
+	}
+	if pkg.IsBitwise(operation) {
+		return calcIntegerRat(operation, args)
+	}
+	var a = args[0]
+	switch operation {
+	case pkg.UnaryMinus:
+		return new(big.Rat).Neg(a), ""
+	case pkg.UnaryPlus:
+		return a, ""
+	}
+	var b = args[1]
+	switch operation {
+	case "+":
+		return new(big.Rat).Add(a, b), ""
+	case "-":
+		return new(big.Rat).Sub(a, b), ""
+	case "*":
+		return new(big.Rat).Mul(a, b), ""
+	case "/", pkg.Modulo, pkg.IntDivision:
+		if b.Sign() == 0 {
+			return nil, backend.DivisionByZeroCode
+		}
+		var quotient = new(big.Rat).Quo(a, b)
+		switch operation {
+		case pkg.IntDivision:
+			return floorRat(quotient), ""
+		case pkg.Modulo: // как и floorMod: a - b * floor(a / b), знак совпадает со знаком b.
+			return new(big.Rat).Sub(a, new(big.Rat).Mul(b, floorRat(quotient))), ""
+		}
+		return quotient, ""
+	case pkg.Power:
+		return powRat(a, b)
+	}
+	return nil, backend.UnknownOperationCode
+}
+
+// powRat возводит a в целую степень b. Дробный показатель дал бы иррациональный результат, поэтому он —
+// DomainErrorCode.
+func powRat(a, b *big.Rat) (*big.Rat, backend.ErrorCode) {
+	if !b.IsInt() {
+		return nil, backend.DomainErrorCode
+	}
+	var exponent = new(big.Int).Abs(b.Num())
+	if a.Sign() == 0 {
+		if b.Sign() < 0 { // 0^-n = 1/0^n.
+			return nil, backend.DivisionByZeroCode
+		}
+		if b.Sign() == 0 {
+			return new(big.Rat).Set(one), ""
+		}
+		return new(big.Rat), ""
+	}
+	var abs = new(big.Rat).Abs(a)
+	if abs.Cmp(one) != 0 {
+		var size = int64(max(a.Num().BitLen(), a.Denom().BitLen()))
+		if !exponent.IsInt64() || exponent.Int64() > maxExactBits/size {
+			return nil, backend.OverflowCode
+		}
+	} else {
+		exponent.Mod(exponent, big.NewInt(2)) // у ±1 важна только чётность показателя.
+	}
+	var (
+		num    = new(big.Int).Exp(a.Num(), exponent, nil)
+		den    = new(big.Int).Exp(a.Denom(), exponent, nil)
+		result = new(big.Rat).SetFrac(num, den)
+	)
+	if b.Sign() < 0 {
+		result.Inv(result)
+	}
+	return result, ""
+}
+
+func floorRat(x *big.Rat) *big.Rat {
+	// Знаменатель big.Rat всегда положителен, а DivMod делит с неотрицательным остатком, то есть округляет вниз.
+	var quotient, _ = new(big.Int).DivMod(x.Num(), x.Denom(), new(big.Int))
+	return new(big.Rat).SetInt(quotient)
+}
+
+// roundRat округляет половину от нуля, как math.Round.
+func roundRat(x *big.Rat) *big.Rat {
+	var result = floorRat(new(big.Rat).Add(new(big.Rat).Abs(x), half))
+	if x.Sign() < 0 {
+		result.Neg(result)
+	}
+	return result
+}
+
+// selectRat возвращает наименьший (sign = -1) или наибольший (sign = 1) аргумент.
+func selectRat(args []*big.Rat, sign int) *big.Rat {
+	var result = args[0]
+	for _, arg := range args[1:] {
+		if arg.Cmp(result) == sign {
+			result = arg
+		}
+	}
+	return result
+}
+
+func exactUnaryFunction(function func(*big.Rat) *big.Rat) func([]*big.Rat, uint) (*big.Rat, backend.ErrorCode) {
+	return func(args []*big.Rat, _ uint) (*big.Rat, backend.ErrorCode) {
+		return function(args[0]), ""
+	}
+}
+
+func checkInt64(result *big.Rat) backend.ErrorCode {
+	if !result.IsInt() {
+		return backend.DomainErrorCode
+	}
+	if !result.Num().IsInt64() {
+		return backend.OverflowCode
+	}
+	return ""
+}
+
+// parseExactArgs разбирает аргументы-строки: в int64 — целые числа, в big и rational — дроби "a/b" или целые.
+func parseExactArgs(precision pkg.Precision, rawArgs []interface{}) (args []*big.Rat, ok bool) {
+	for _, rawArg := range rawArgs {
+		text, isString := rawArg.(string)
+		if !isString {
+			return nil, false
+		}
+		var arg *big.Rat
+		if precision == pkg.Int64 {
+			integer, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			arg = big.NewRat(integer, 1)
+		} else if arg, ok = new(big.Rat).SetString(text); !ok {
+			return nil, false
+		}
+		args = append(args, arg)
+	}
+	return args, len(args) > 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"log"
+	"sync"
+	"time"
+)
+
+// Run раз в pollingInterval запрашивает у оркестратора задачи и считает их в computingPower горутинах. После
+// отмены ctx новые задачи не запрашиваются, а уже полученные досчитываются и отправляются; Run возвращается, когда
+// отправлен последний результат.
+func (a *Agent) Run(ctx context.Context, computingPower int, pollingInterval time.Duration) {
+	var (
+		calcWg           sync.WaitGroup
+		results          = make(chan backend.AgentResult, computingPower)
+		tasksReadyToCalc = make(chan *backend.Task, computingPower)
+		sendDone         = make(chan struct{})
+	)
+	for range computingPower {
+		calcWg.Add(1)
+		go func() {
+			defer calcWg.Done()
+			for task := range tasksReadyToCalc {
+				agentResult, err := a.calc(task)
+				if err != nil {
+					log.Println(err, task.PairID)
+				}
+				results <- agentResult
+			}
+		}()
+	}
+	go func() {
+		defer close(sendDone)
+		for result := range results {
+			if err := a.send(result); err != nil {
+				log.Println(err, result.ID)
+			}
+		}
+	}()
+
+	var ticker = time.NewTicker(pollingInterval)
+	defer ticker.Stop()
+poll:
+	for {
+		select {
+		case <-ctx.Done():
+			break poll
+		case <-ticker.C:
+			task, ok, err := a.get()
+			if err != nil {
+				log.Println("не удалось получить задачу:", err)
+				continue
+			}
+			if ok {
+				tasksReadyToCalc <- task
+			}
+		}
+	}
+	close(tasksReadyToCalc)
+	calcWg.Wait()
+	close(results)
+	<-sendDone
+}
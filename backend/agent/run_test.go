@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAgentRunShutdown проверяет, что после отмены контекста агент перестаёт запрашивать задачи, но отправляет
+// результат уже полученной.
+func TestAgentRunShutdown(t *testing.T) {
+	var (
+		mut       sync.Mutex
+		taskSent  bool
+		results   []backend.AgentResult
+		gotResult = make(chan struct{})
+		server    = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mut.Lock()
+			defer mut.Unlock()
+			switch r.Method {
+			case http.MethodGet:
+				if taskSent {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				taskSent = true
+				json.NewEncoder(w).Encode(backend.TaskToSend{Task: &backend.Task{PairID: 4, Arg1: 2.0, Arg2: 3.0,
+					Operation: "*"}})
+			case http.MethodPost:
+				var result backend.AgentResult
+				json.NewDecoder(r.Body).Decode(&result)
+				results = append(results, result)
+				close(gotResult)
+			}
+		}))
+	)
+	defer server.Close()
+	var config = ConfigDefaultFabric()
+	config.OrchestratorURL = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var done = make(chan struct{})
+	go func() {
+		defer close(done)
+		AgentFabric(config).Run(ctx, 2, time.Millisecond)
+	}()
+	select {
+	case <-gotResult:
+	case <-time.After(time.Second):
+		t.Fatal("агент не отправил результат")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("агент не остановился")
+	}
+	mut.Lock()
+	defer mut.Unlock()
+	assert.Equal(t, []backend.AgentResult{{ID: 4, Result: 6}}, results)
+}
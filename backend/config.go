@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Setting — один параметр конфигурации оркестратора или агента. Значение может прийти из конфигурационного файла
+// (ключ Name), переменной среды Env или флага -Name. Источники применяются по порядку: значение по умолчанию <
+// файл < переменная среды < флаг, то есть флаг перекрывает всё остальное.
+type Setting struct {
+	Name  string
+	Env   string
+	Usage string
+	parse func(value string) error
+	value func() string // текущее значение; используется для вывода значения по умолчанию в -help.
+}
+
+func StringSetting(name, env, usage string, target *string) Setting {
+	return Setting{Name: name, Env: env, Usage: usage,
+		parse: func(value string) error {
+			*target = value
+			return nil
+		},
+		value: func() string {
+			return *target
+		}}
+}
+
+func IntSetting(name, env, usage string, target *int) Setting {
+	return Setting{Name: name, Env: env, Usage: usage,
+		parse: func(value string) (err error) {
+			*target, err = strconv.Atoi(value)
+			return
+		},
+		value: func() string {
+			return strconv.Itoa(*target)
+		}}
+}
+
+func DurationSetting(name, env, usage string, target *time.Duration) Setting {
+	return Setting{Name: name, Env: env, Usage: usage,
+		parse: func(value string) (err error) {
+			*target, err = time.ParseDuration(value)
+			return
+		},
+		value: func() string {
+			return target.String()
+		}}
+}
+
+// ConfigError — ошибка в значении параметра с указанием, откуда оно пришло.
+type ConfigError struct {
+	Source string // флаг, переменная среды или файл.
+	Name   string
+	Err    error
+}
+
+func (c ConfigError) Error() string {
+	return fmt.Sprintf("некорректное значение %s (%s): %s", c.Name, c.Source, c.Err)
+}
+
+func (c ConfigError) Unwrap() error {
+	return c.Err
+}
+
+// LoadSettings заполняет параметры settings из args (без имени программы), переменных среды и конфигурационного
+// файла. Путь к файлу задаётся флагом -config или переменной среды configEnv; без них файл не читается. Если
+// среди args есть -help, возвращается flag.ErrHelp.
+func LoadSettings(programName string, settings []Setting, configEnv string, args []string, output io.Writer) error {
+	var (
+		flagSet     = flag.NewFlagSet(programName, flag.ContinueOnError)
+		flagValues  = make(map[string]*string, len(settings))
+		configPath  = flagSet.String("config", "", "путь к конфигурационному файлу YAML (или переменная "+configEnv+")")
+		explicitSet = make(map[string]bool)
+	)
+	flagSet.SetOutput(output)
+	for _, setting := range settings {
+		flagValues[setting.Name] = flagSet.String(setting.Name, setting.value(),
+			fmt.Sprintf("%s (переменная %s)", setting.Usage, setting.Env))
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() > 0 {
+		return fmt.Errorf("неожиданные аргументы: %v", flagSet.Args())
+	}
+	flagSet.Visit(func(f *flag.Flag) {
+		explicitSet[f.Name] = true
+	})
+
+	if !explicitSet["config"] {
+		*configPath = os.Getenv(configEnv)
+	}
+	if *configPath != "" {
+		if err := loadConfigFile(*configPath, settings); err != nil {
+			return err
+		}
+	}
+	for _, setting := range settings {
+		value, ok := os.LookupEnv(setting.Env)
+		if !ok || value == "" {
+			continue
+		}
+		if err := setting.parse(value); err != nil {
+			return ConfigError{Source: "переменная среды " + setting.Env, Name: setting.Name, Err: err}
+		}
+	}
+	for _, setting := range settings {
+		if !explicitSet[setting.Name] {
+			continue
+		}
+		if err := setting.parse(*flagValues[setting.Name]); err != nil {
+			return ConfigError{Source: "флаг -" + setting.Name, Name: setting.Name, Err: err}
+		}
+	}
+	return nil
+}
+
+// loadConfigFile читает плоский YAML-файл вида `ключ: значение`, где ключи совпадают с именами флагов.
+func loadConfigFile(path string, settings []Setting) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("не удалось прочитать конфигурационный файл: %w", err)
+	}
+	var values map[string]any
+	if err = yaml.Unmarshal(buf, &values); err != nil {
+		return fmt.Errorf("не удалось разобрать конфигурационный файл %s: %w", path, err)
+	}
+	var byName = make(map[string]Setting, len(settings))
+	for _, setting := range settings {
+		byName[setting.Name] = setting
+	}
+	for key, value := range values {
+		setting, ok := byName[key]
+		if !ok {
+			return ConfigError{Source: "файл " + path, Name: key, Err: errors.New("неизвестный параметр")}
+		}
+		if err = setting.parse(fmt.Sprint(value)); err != nil {
+			return ConfigError{Source: "файл " + path, Name: key, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"maps"
+	"os"
+	"sync"
+)
+
+var (
+	ConstantNotFound    = errors.New("константа не найдена")
+	BuiltinConstant     = errors.New("встроенную константу нельзя изменить")
+	InvalidConstantName = errors.New("некорректное имя константы")
+)
+
+// ConstantsRegistry — именованные константы команды (например, VAT_RATE). При отправке выражения они
+// подставляются наравне со встроенными pi, e, tau и inf, поэтому последующие изменения реестра на уже принятые
+// выражения не влияют. Если задан path, реестр целиком сохраняется в этот JSON-файл после каждого изменения.
+type ConstantsRegistry struct {
+	mut    sync.RWMutex
+	values map[string]float64
+	path   string
+}
+
+func ConstantsRegistryEmptyFabric() *ConstantsRegistry {
+	return &ConstantsRegistry{values: make(map[string]float64)}
+}
+
+// ConstantsRegistryFabricWithFile загружает константы из path. Отсутствующий файл считается пустым реестром.
+func ConstantsRegistryFabricWithFile(path string) (*ConstantsRegistry, error) {
+	var result = &ConstantsRegistry{values: make(map[string]float64), path: path}
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(buf, &result.values); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Get возвращает значение константы команды; встроенные константы сюда не входят.
+func (c *ConstantsRegistry) Get(name string) (value float64, ok bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	value, ok = c.values[name]
+	return
+}
+
+// GetAll возвращает копию всех констант команды.
+func (c *ConstantsRegistry) GetAll() map[string]float64 {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return maps.Clone(c.values)
+}
+
+// Set регистрирует константу или меняет её значение. created == true, если константы раньше не было.
+func (c *ConstantsRegistry) Set(name string, value float64) (created bool, err error) {
+	if !pkg.IsVariableName(name) {
+		return false, InvalidConstantName
+	}
+	if pkg.IsConstant(name) {
+		return false, BuiltinConstant
+	}
+	if _, isFunction := pkg.GetFunctionArity(name); isFunction { // иначе sqrt в выражении стал бы и числом.
+		return false, InvalidConstantName
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	oldValue, existed := c.values[name]
+	c.values[name] = value
+	if err = c.save(); err != nil {
+		if existed {
+			c.values[name] = oldValue
+		} else {
+			delete(c.values, name)
+		}
+		return false, err
+	}
+	return !existed, nil
+}
+
+func (c *ConstantsRegistry) Delete(name string) (err error) {
+	if pkg.IsConstant(name) {
+		return BuiltinConstant
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	oldValue, existed := c.values[name]
+	if !existed {
+		return ConstantNotFound
+	}
+	delete(c.values, name)
+	if err = c.save(); err != nil {
+		c.values[name] = oldValue
+	}
+	return
+}
+
+// save атомарно перезаписывает файл реестра. Вызывается под c.mut.
+func (c *ConstantsRegistry) save() (err error) {
+	if c.path == "" {
+		return nil
+	}
+	buf, err := json.MarshalIndent(c.values, "", "  ")
+	if err != nil {
+		return
+	}
+	var tmpPath = c.path + ".tmp"
+	if err = os.WriteFile(tmpPath, buf, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmpPath, c.path)
+}
+
+// ConstantJson — константа в запросах и ответах API констант.
+type ConstantJson struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+func (c ConstantJson) Marshal() (result []byte, err error) {
+	result, err = json.Marshal(&c)
+	return
+}
+
+// ConstantValueJson — тело запроса PUT /api/v1/constants/{name}.
+type ConstantValueJson struct {
+	Value *float64 `json:"value"`
+}
+
+func (c ConstantValueJson) Marshal() (result []byte, err error) {
+	result, err = json.Marshal(&c)
+	return
+}
+
+// ConstantsJsonTitle — ответ GET /api/v1/constants. Builtin — только имена: значение inf в JSON не записать.
+type ConstantsJsonTitle struct {
+	Constants []ConstantJson `json:"constants"`
+	Builtin   []string       `json:"builtin"`
+}
+
+func (c ConstantsJsonTitle) Marshal() (result []byte, err error) {
+	result, err = json.Marshal(&c)
+	return
+}
@@ -6,17 +6,18 @@ import (
 	"time"
 )
 
+// TimeoutExecution описывает задачу, которую агенты так и не посчитали: аренда истекла attempts раз подряд.
 type TimeoutExecution struct {
-	operationTime time.Duration
-	factTime      time.Duration
-	operation     string
-	pairId        int
+	leaseTime time.Duration
+	attempts  int
+	operation string
+	pairId    int
 }
 
 func (t TimeoutExecution) Error() string {
 	exprId, taskId := pkg.Unpair(t.pairId)
 	return fmt.Sprintf("возник timeout при обработке task: %d из expression %d, оператор: %s, время на выполнение: %s,"+
-		"фактически: %s", taskId, exprId, t.operation, t.operationTime, t.factTime)
+		" попыток: %d", taskId, exprId, t.operation, t.leaseTime, t.attempts)
 }
 
 type TaskIDNotExist struct {
@@ -26,3 +27,24 @@ type TaskIDNotExist struct {
 func (t TaskIDNotExist) Error() string {
 	return fmt.Sprintf("задачи с ID %d не найдена", t.taskId)
 }
+
+// InvalidTaskResult возвращается, когда агент прислал результат не в том виде, которого требует режим точности
+// выражения (см. pkg.Precision): например, число вместо строки или дробь в режиме int64.
+type InvalidTaskResult struct {
+	taskId int
+	value  string
+}
+
+func (i InvalidTaskResult) Error() string {
+	return fmt.Sprintf("некорректный результат задачи с ID %d: '%s'", i.taskId, i.value)
+}
+
+// LateTaskResult возвращается, когда результат пришёл по задаче, которая уже не числится отправленной: её аренда
+// истекла и задача вернулась в очередь, или результат уже был записан. Такой результат игнорируется.
+type LateTaskResult struct {
+	taskId int
+}
+
+func (l LateTaskResult) Error() string {
+	return fmt.Sprintf("результат задачи с ID %d пришёл после истечения аренды и проигнорирован", l.taskId)
+}
@@ -1,13 +1,20 @@
 package backend
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/Debianov/calc-ya-go-24/pkg"
 	"go/types"
 	"log"
-	"os"
+	"maps"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,7 +24,34 @@ type JsonPayload interface {
 }
 
 type RequestJson struct {
-	Expression string `json:"expression"`
+	Expression string             `json:"expression"`
+	Variables  map[string]float64 `json:"variables,omitempty"` // значения переменных, встречающихся в Expression.
+	Simplify   bool               `json:"simplify,omitempty"`  // упростить выражение через pkg.Simplify перед разбиением на задачи.
+	Precision  string             `json:"precision,omitempty"` // режим точности (см. pkg.Precision); по умолчанию float64.
+	Bits       uint               `json:"bits,omitempty"`      // точность big.Float в режиме big; по умолчанию pkg.DefaultBits.
+}
+
+// GetVariable — pkg.VariableLookup по Variables.
+func (r RequestJson) GetVariable(name string) (value float64, ok bool) {
+	value, ok = r.Variables[name]
+	return
+}
+
+// GetPrecision возвращает режим точности и точность big.Float из запроса. bits допустим только в режиме big;
+// если он не задан, берётся pkg.DefaultBits.
+func (r RequestJson) GetPrecision() (precision pkg.Precision, bits uint, err error) {
+	if precision, err = pkg.ParsePrecision(r.Precision); err != nil {
+		return
+	}
+	switch {
+	case precision != pkg.Big && r.Bits != 0, r.Bits != 0 && (r.Bits < pkg.MinBits || r.Bits > pkg.MaxBits):
+		return "", 0, pkg.UnsupportedPrecision
+	case precision == pkg.Big && r.Bits == 0:
+		bits = pkg.DefaultBits
+	default:
+		bits = r.Bits
+	}
+	return
 }
 
 func (r RequestJson) Marshal() (result []byte, err error) {
@@ -49,8 +83,86 @@ func (o OKJson) Marshal() (result []byte, err error) {
 	return
 }
 
+// ErrorCode — машиночитаемый код ошибки, по которому клиент может отличить одну ошибку от другой, не разбирая
+// текст сообщения.
+type ErrorCode string
+
+const (
+	MethodNotAllowedCode     ErrorCode = "method_not_allowed"
+	UnsupportedMediaTypeCode ErrorCode = "unsupported_media_type"
+	MalformedJsonCode        ErrorCode = "malformed_json"
+	InvalidPayloadCode       ErrorCode = "invalid_payload"
+	InvalidExpressionCode    ErrorCode = "invalid_expression"
+	InvalidIdCode            ErrorCode = "invalid_id"
+	ExpressionNotFoundCode   ErrorCode = "expression_not_found"
+	TaskNotFoundCode         ErrorCode = "task_not_found"
+	TaskTimeoutCode          ErrorCode = "task_timeout"
+	NoReadyTasksCode         ErrorCode = "no_ready_tasks"
+	EndpointNotFoundCode     ErrorCode = "endpoint_not_found"
+	InternalErrorCode        ErrorCode = "internal_error"
+	UnboundVariableCode      ErrorCode = "unbound_variable"
+	InvalidVariableCode      ErrorCode = "invalid_variable"
+	InvalidNumberCode        ErrorCode = "invalid_number"
+	InvalidConstantCode      ErrorCode = "invalid_constant"
+	ConstantNotFoundCode     ErrorCode = "constant_not_found"
+	BuiltinConstantCode      ErrorCode = "builtin_constant"
+	UnauthorizedCode         ErrorCode = "unauthorized"
+	AdminDisabledCode        ErrorCode = "admin_disabled"
+	InvalidPrecisionCode     ErrorCode = "invalid_precision"
+	UnsupportedOperationCode ErrorCode = "unsupported_operation"
+	NotAnIntegerCode         ErrorCode = "not_an_integer"
+	TypeMismatchCode         ErrorCode = "type_mismatch"
+
+	// Коды ошибок вычисления задач. Отправляются агентом в AgentResult и показываются в выражении.
+	DivisionByZeroCode   ErrorCode = "division_by_zero"
+	OverflowCode         ErrorCode = "overflow"
+	DomainErrorCode      ErrorCode = "domain_error"
+	UnknownOperationCode ErrorCode = "unknown_operation"
+)
+
+var errorMessages = map[ErrorCode]string{
+	MethodNotAllowedCode:     "метод не поддерживается",
+	UnsupportedMediaTypeCode: "ожидается Content-Type: application/json",
+	MalformedJsonCode:        "тело запроса не является корректным JSON",
+	InvalidPayloadCode:       "JSON в теле запроса не соответствует ожидаемой структуре",
+	InvalidExpressionCode:    "некорректное выражение",
+	InvalidIdCode:            "ID должен быть целым числом",
+	ExpressionNotFoundCode:   "выражение не найдено",
+	TaskNotFoundCode:         "задача не найдена",
+	TaskTimeoutCode:          "задача не посчитана за отведённое время после всех повторных отправок",
+	NoReadyTasksCode:         "нет готовых задач",
+	EndpointNotFoundCode:     "endpoint не найден",
+	InternalErrorCode:        "внутренняя ошибка сервера",
+	UnboundVariableCode:      "в выражении есть переменная без значения",
+	InvalidVariableCode: "имя переменной должно начинаться с латинской буквы или _ и состоять из латинских букв, " +
+		"цифр и _",
+	InvalidNumberCode: "некорректная запись числа",
+	InvalidConstantCode: "имя константы должно начинаться с латинской буквы или _, состоять из латинских букв, " +
+		"цифр и _ и не совпадать с именем функции",
+	ConstantNotFoundCode:     "константа не найдена",
+	BuiltinConstantCode:      "встроенную константу нельзя изменить или удалить",
+	UnauthorizedCode:         "нужен заголовок Authorization: Bearer с токеном администратора",
+	AdminDisabledCode:        "изменение констант отключено: на сервере не задан токен администратора",
+	InvalidPrecisionCode:     "precision — float64, int64, big, rational или complex, а bits — от 64 до 4096 для big",
+	UnsupportedOperationCode: "операция не поддерживается в выбранном режиме точности",
+	NotAnIntegerCode:         "в int64 и в побитовых операторах float64 (до 2^53 по модулю) допустимы только целые",
+	TypeMismatchCode:         "тип операнда не подходит оператору: например, число вместо логического значения",
+	DivisionByZeroCode:       "деление на ноль",
+	OverflowCode:             "переполнение: результат не помещается в число",
+	DomainErrorCode:          "операция не определена для данных аргументов",
+	UnknownOperationCode:     "неизвестная операция",
+}
+
+// ErrorJson — единый формат ответа с ошибкой для всех endpoint-ов оркестратора.
 type ErrorJson struct {
-	Error string `json:"error"`
+	Error ErrorDetails `json:"error"`
+}
+
+type ErrorDetails struct {
+	Code     ErrorCode `json:"code"`
+	Message  string    `json:"message"`
+	Position *int      `json:"position,omitempty"` // смещение проблемного токена в выражении, только для ошибок парсинга.
+	Token    string    `json:"token,omitempty"`
 }
 
 func (e ErrorJson) Marshal() (result []byte, err error) {
@@ -58,6 +170,38 @@ func (e ErrorJson) Marshal() (result []byte, err error) {
 	return
 }
 
+// ErrorJsonFabric создаёт ErrorJson со стандартным сообщением для code.
+func ErrorJsonFabric(code ErrorCode) ErrorJson {
+	return ErrorJson{Error: ErrorDetailsFabric(code)}
+}
+
+// ErrorDetailsFabric создаёт ErrorDetails со стандартным сообщением для code.
+func ErrorDetailsFabric(code ErrorCode) ErrorDetails {
+	return ErrorDetails{Code: code, Message: errorMessages[code]}
+}
+
+// ParseErrorJsonFabric создаёт ErrorJson с позицией и токеном, на котором разбор выражения завершился ошибкой.
+func ParseErrorJsonFabric(parseError pkg.ParseError) ErrorJson {
+	var (
+		position = parseError.Position
+		code     = InvalidExpressionCode
+	)
+	switch {
+	case errors.Is(parseError, pkg.UnboundVariable):
+		code = UnboundVariableCode
+	case errors.Is(parseError, pkg.MalformedNumber), errors.Is(parseError, pkg.NumberOutOfRange):
+		code = InvalidNumberCode
+	case errors.Is(parseError, pkg.UnsupportedOperation):
+		code = UnsupportedOperationCode
+	case errors.Is(parseError, pkg.NotAnInteger):
+		code = NotAnIntegerCode
+	case errors.Is(parseError, pkg.TypeMismatch):
+		code = TypeMismatchCode
+	}
+	return ErrorJson{Error: ErrorDetails{Code: code, Message: parseError.Error(), Position: &position,
+		Token: parseError.Token}}
+}
+
 type EmptyJson struct {
 }
 
@@ -72,6 +216,7 @@ const (
 	NoReadyTasks            = "Нет готовых задач"
 	Completed               = "Выполнено"
 	Cancelled               = "Отменено"
+	Failed                  = "Ошибка вычисления"
 )
 
 const (
@@ -79,101 +224,461 @@ const (
 	TIME_SUBTRACTION_MS            = "TIME_SUBTRACTION_MS"
 	TIME_MULTIPLICATIONS_MS        = "TIME_MULTIPLICATIONS_MS"
 	TIME_DIVISIONS_MS              = "TIME_DIVISIONS_MS"
+	TIME_POWER_MS                  = "TIME_POWER_MS"
+	TIME_MODULO_MS                 = "TIME_MODULO_MS"
+	TIME_INTDIV_MS                 = "TIME_INTDIV_MS"
+	TIME_COMPARISON_MS             = "TIME_COMPARISON_MS"
+	TIME_LOGICAL_MS                = "TIME_LOGICAL_MS"
+	TIME_BITWISE_MS                = "TIME_BITWISE_MS"
 )
 
+// operationTimeEnvNames связывает оператор или функцию с переменной, задающей время выполнения. Унарные операторы
+// считаются как сложение и вычитание из нуля, у каждой функции своя переменная вида TIME_SQRT_MS. У всех сравнений
+// одна переменная TIME_COMPARISON_MS, у логических операторов — TIME_LOGICAL_MS, у побитовых и сдвигов —
+// TIME_BITWISE_MS. У pkg.Conditional времени нет: агентам эта задача не отправляется.
+var operationTimeEnvNames = getOperationTimeEnvNames()
+
+// operationTimeSettingNames — имена флагов и ключей конфигурационного файла для времени операций.
+var operationTimeSettingNames = getOperationTimeSettingNames()
+
+func getOperationTimeEnvNames() map[string]string {
+	var result = map[string]string{"+": TIME_ADDITION_MS, "-": TIME_SUBTRACTION_MS, "*": TIME_MULTIPLICATIONS_MS,
+		"/": TIME_DIVISIONS_MS, pkg.Power: TIME_POWER_MS, pkg.Modulo: TIME_MODULO_MS,
+		pkg.IntDivision: TIME_INTDIV_MS, pkg.UnaryPlus: TIME_ADDITION_MS, pkg.UnaryMinus: TIME_SUBTRACTION_MS,
+		pkg.Less: TIME_COMPARISON_MS, pkg.LessEqual: TIME_COMPARISON_MS, pkg.Greater: TIME_COMPARISON_MS,
+		pkg.GreaterEqual: TIME_COMPARISON_MS, pkg.Equal: TIME_COMPARISON_MS, pkg.NotEqual: TIME_COMPARISON_MS,
+		pkg.LogicalAnd: TIME_LOGICAL_MS, pkg.LogicalOr: TIME_LOGICAL_MS, pkg.LogicalNot: TIME_LOGICAL_MS,
+		pkg.BitwiseAnd: TIME_BITWISE_MS, pkg.BitwiseOr: TIME_BITWISE_MS, pkg.BitwiseXor: TIME_BITWISE_MS,
+		pkg.BitwiseNot: TIME_BITWISE_MS, pkg.ShiftLeft: TIME_BITWISE_MS, pkg.ShiftRight: TIME_BITWISE_MS}
+	for _, function := range getTimedFunctionNames() {
+		result[function] = getFunctionTimeEnvName(function)
+	}
+	return result
+}
+
+func getOperationTimeSettingNames() map[string]string {
+	var result = map[string]string{TIME_ADDITION_MS: "time-addition", TIME_SUBTRACTION_MS: "time-subtraction",
+		TIME_MULTIPLICATIONS_MS: "time-multiplication", TIME_DIVISIONS_MS: "time-division",
+		TIME_POWER_MS: "time-power", TIME_MODULO_MS: "time-modulo", TIME_INTDIV_MS: "time-intdiv",
+		TIME_COMPARISON_MS: "time-comparison", TIME_LOGICAL_MS: "time-logical", TIME_BITWISE_MS: "time-bitwise"}
+	for _, function := range getTimedFunctionNames() {
+		result[getFunctionTimeEnvName(function)] = "time-" + function
+	}
+	return result
+}
+
+// getTimedFunctionNames возвращает функции, которые считают агенты.
+func getTimedFunctionNames() []string {
+	return slices.DeleteFunc(pkg.FunctionNames(), func(function string) bool {
+		return function == pkg.Conditional
+	})
+}
+
+func getFunctionTimeEnvName(function string) string {
+	return "TIME_" + strings.ToUpper(function) + "_MS"
+}
+
+// OperationTimes — время выполнения операций, которое оркестратор передаёт агентам вместе с задачей. Ключ — имя
+// переменной среды, задающей время (TIME_ADDITION_MS и т.д.).
+type OperationTimes map[string]time.Duration
+
+// OperationTimesDefaultFabric возвращает время операций по умолчанию: по секунде на каждую.
+func OperationTimesDefaultFabric() OperationTimes {
+	var result = make(OperationTimes, len(operationTimeSettingNames))
+	for envName := range operationTimeSettingNames {
+		result[envName] = time.Second
+	}
+	return result
+}
+
+// Settings возвращает параметры конфигурации, которыми задаётся время каждой операции.
+func (o OperationTimes) Settings() (result []Setting) {
+	for _, envName := range slices.Sorted(maps.Keys(operationTimeSettingNames)) {
+		var target = envName
+		result = append(result, Setting{Name: operationTimeSettingNames[envName], Env: envName,
+			Usage: "время выполнения операции",
+			parse: func(value string) (err error) {
+				o[target], err = time.ParseDuration(value)
+				return
+			},
+			value: func() string {
+				return o[target].String()
+			}})
+	}
+	return
+}
+
+// Validate проверяет, что время задано для каждой операции и не отрицательно.
+func (o OperationTimes) Validate() error {
+	for _, envName := range slices.Sorted(maps.Keys(operationTimeSettingNames)) {
+		duration, ok := o[envName]
+		if !ok {
+			return fmt.Errorf("не задано время операции %s", envName)
+		}
+		if duration < 0 {
+			return fmt.Errorf("время операции %s не может быть отрицательным: %s", envName, duration)
+		}
+	}
+	return nil
+}
+
 type TaskToSend struct {
 	Task              *Task `json:"task"`
 	timeAtSendingTask time.Time
+	deadline          time.Time // после этого момента задача считается потерянной и возвращается в очередь.
+}
+
+// LeasePolicy задаёт аренду отправленных задач: на задачу агенту даётся OperationTime + Grace, после чего задача
+// возвращается в очередь. Если задача не посчитана и после MaxRetries повторных отправок, выражение получает
+// статус Failed.
+type LeasePolicy struct {
+	Grace      time.Duration
+	MaxRetries int
 }
 
+var DefaultLeasePolicy = LeasePolicy{Grace: 5 * time.Second, MaxRetries: 3}
+
 func (t *TaskToSend) Marshal() (result []byte, err error) {
 	result, err = json.Marshal(&t)
 	return
 }
 
 type Expression struct {
-	postfix      []string
-	ID           int        `json:"id"`
-	Status       ExprStatus `json:"status"`
-	Result       int64      `json:"result"`
-	tasksHandler *Tasks
-	mut          sync.Mutex
+	tree   pkg.Node   // дерево с уже подставленными значениями переменных.
+	ID     int        `json:"id"`
+	Source string     `json:"source,omitempty"` // выражение в том виде, в каком его отправил клиент.
+	Status ExprStatus `json:"status"`
+	Result float64    `json:"result"`
+	// Value — точный результат в режимах int64, big и rational десятичной записью; Result тогда — его приближение
+	// float64. В rational дробь без конечной десятичной записи в Value округляется, а точный результат — Fraction.
+	Value      string             `json:"value,omitempty"`
+	Fraction   string             `json:"fraction,omitempty"` // несократимая дробь "1/2" в режиме rational.
+	Imag       float64            `json:"-"`                  // мнимая часть результата в режиме complex, см. MarshalJSON.
+	Boolean    *bool              `json:"-"`                  // логический результат выражения, см. MarshalJSON.
+	Precision  pkg.Precision      `json:"precision,omitempty"`
+	Bits       uint               `json:"bits,omitempty"`       // точность big.Float в режиме big.
+	Error      *ErrorDetails      `json:"error,omitempty"`      // причина, по которой выражение получило статус Failed.
+	Variables  map[string]float64 `json:"variables,omitempty"`  // значения, подставленные при отправке.
+	TasksSaved int                `json:"tasksSaved,omitempty"` // сколько задач не понадобилось благодаря упрощению.
+	// TasksReused — сколько задач не понадобилось, потому что одинаковые поддеревья посчитаны один раз или их
+	// результат взят из MemoCache.
+	TasksReused    int `json:"tasksReused,omitempty"`
+	tasksHandler   *Tasks
+	memo           *MemoCache
+	knownResults   map[int]interface{} // результаты поддеревьев, подставленные при разбиении на задачи, по PairID.
+	lowering       *loweringState      // nil, пока дерево не разбито на задачи.
+	leasePolicy    LeasePolicy
+	operationTimes OperationTimes
+	storage        ExpressionStorage // nil, если выражение не нужно сохранять.
+	persistMut     sync.Mutex
+	mut            sync.Mutex
+}
+
+// taskArg — аргумент задачи: либо уже известное значение, либо задача, результат которой станет аргументом.
+// Значения аргументов и результатов задач — float64 в режиме float64 (bool у сравнений и логических операторов),
+// complex128 в complex и строки с точной записью в точных режимах (см. pkg.Precision).
+type taskArg struct {
+	value    interface{}
+	producer *Task
+}
+
+// resolved заменяет уже посчитанную задачу её результатом: ветвь pkg.Conditional разбивается на задачи позже
+// остального дерева, и одинаковое поддерево к этому времени может быть посчитано.
+func (a taskArg) resolved() taskArg {
+	if a.producer != nil {
+		if result, ok := a.producer.getResult(); ok {
+			return taskArg{value: result}
+		}
+	}
+	return a
 }
 
+// loweringState — всё, что нужно для разбиения поддеревьев выражения на задачи. Оно хранится в выражении, потому что
+// ветви pkg.Conditional разбиваются на задачи не сразу, а когда посчитано условие (см. resolveConditional).
+type loweringState struct {
+	hashes     map[pkg.Node]pkg.Hash
+	operations map[pkg.Node]int // число операторов в поддереве.
+	offsets    map[pkg.Node]int // номер первого оператора поддерева в постфиксе всего дерева.
+	lowered    map[pkg.Hash]taskArg
+	known      map[int]interface{}
+	reused     int
+	// mut блокирует разбиение на задачи вместе с передачей результатов между задачами: ветвь условия может
+	// подписаться на задачу, результат которой как раз передаётся.
+	mut sync.Mutex
+}
+
+// Аргументы задачи-условия pkg.Conditional: результат условия и результат выбранной ветви.
+const (
+	conditionSlot = 0
+	branchSlot    = 1
+)
+
+// DivideIntoTasks разбивает дерево выражения на задачи: каждый оператор и вызов функции становится задачей, а его
+// операнды — её аргументами. Одинаковые поддеревья (например, обе части (a+b)*(a+b)) считаются одной задачей, а
+// поддеревья, результат которых уже есть в MemoCache, сразу заменяются числом. Сколько задач так удалось не
+// создавать, записывается в TasksReused.
 func (e *Expression) DivideIntoTasks() {
+	e.TasksReused = e.divideIntoTasks(nil)
+}
+
+// divideIntoTasks строит граф задач. known — результаты задач по PairID, известные заранее (посчитанные до
+// перезапуска): такие поддеревья сразу становятся числами. Номер задачи — номер её оператора в постфиксе всего
+// дерева, даже если часть поддеревьев задач не получила, поэтому номера не зависят от содержимого кэша и
+// совпадают при восстановлении.
+func (e *Expression) divideIntoTasks(known map[int]interface{}) (reused int) {
+	e.lowering = &loweringState{hashes: pkg.HashTree(e.tree), operations: make(map[pkg.Node]int),
+		offsets: make(map[pkg.Node]int), lowered: make(map[pkg.Hash]taskArg), known: known}
+	countOperations(e.tree, 0, e.lowering.operations, e.lowering.offsets)
+	e.lowering.mut.Lock()
+	defer e.lowering.mut.Unlock()
+	e.knownResults = make(map[int]interface{})
+	var root = e.lower(e.tree)
+	if root.producer == nil { // выражение из одного числа не требует задач.
+		e.finish(root.value)
+	}
+	return e.lowering.reused
+}
+
+// lower превращает поддерево node в аргумент задачи: число, известный результат или задачу, которая его посчитает.
+// Вызывается под lowering.mut.
+func (e *Expression) lower(node pkg.Node) taskArg {
+	if number, ok := node.(*pkg.NumberNode); ok {
+		return taskArg{value: e.encodeNumber(number)}
+	}
 	var (
-		operatorCount int
-		stack         = pkg.StackFabric[int64]()
+		l          = e.lowering
+		operations = l.operations[node]
+		hash       = e.getMemoKey(l.hashes[node])
+		id         = e.generateId(l.offsets[node] + operations - 1)
 	)
-	for _, r := range e.postfix { // TODO: сделать структуру в постфиксе, уже распарсенную. нам останется пройтись
-		// TODO по ней слева направо и записать всё в порядке <оператор, операнд, операнд>.
-		if pkg.IsNumber(r) {
-			operandInInt, err := strconv.ParseInt(r, 10, 64)
-			if err != nil {
-				log.Panic(err)
-			}
-			stack.Push(operandInInt)
-		} else if pkg.IsOperator(r) {
-			var (
-				newId   = e.generateId(operatorCount)
-				newTask *Task
-			)
-			if stack.Len() >= 2 {
-				newTask = &Task{PairID: newId, Arg2: stack.Pop(), Arg1: stack.Pop(),
-					Operation: r, OperationTime: e.getOperationTime(r), Status: ReadyToCalc}
-			} else if stack.Len() == 1 {
-				newTask = &Task{PairID: newId, Arg2: stack.Pop(), Operation: r,
-					OperationTime: e.getOperationTime(r), Status: WaitingOtherTasks}
-			} else {
-				newTask = &Task{PairID: newId, Operation: r, OperationTime: e.getOperationTime(r),
-					Status: WaitingOtherTasks}
-			}
-			e.tasksHandler.add(newTask)
-			operatorCount++
+	if arg, ok := l.lowered[hash]; ok {
+		l.reused += operations
+		return arg.resolved()
+	}
+	var value, isKnown = l.known[id]
+	if !isKnown && l.known == nil {
+		if value, isKnown = e.memo.Get(hash); isKnown {
+			l.reused += operations
 		}
 	}
-	return
+	if isKnown {
+		e.knownResults[id] = value
+		l.lowered[hash] = taskArg{value: value}
+		return l.lowered[hash]
+	}
+	var (
+		operation string
+		operands  []pkg.Node
+	)
+	switch n := node.(type) {
+	case *pkg.UnaryNode:
+		operation, operands = n.Operator, []pkg.Node{n.Operand}
+	case *pkg.BinaryNode:
+		operation, operands = n.Operator, []pkg.Node{n.Left, n.Right}
+	case *pkg.CallNode:
+		if n.Function == pkg.Conditional {
+			l.lowered[hash] = e.lowerConditional(n, id, hash)
+			return l.lowered[hash]
+		}
+		operation, operands = n.Function, n.Args
+	default: // переменные подставляются до создания выражения.
+		log.Panicf("узел %T не может стать задачей", node)
+	}
+	var args = make([]taskArg, len(operands))
+	for slot, operand := range operands {
+		args[slot] = e.lower(operand)
+	}
+	var newTask = &Task{PairID: id, Operation: operation, OperationTime: e.getOperationTime(operation),
+		Status: ReadyToCalc, hash: hash}
+	if !e.Precision.IsFloat64() {
+		newTask.Precision, newTask.Bits = e.Precision, e.Bits
+	}
+	if newTask.IsFunction() {
+		newTask.Args = make([]interface{}, len(args))
+	}
+	for slot, arg := range args {
+		newTask.bindArg(slot, arg)
+	}
+	e.tasksHandler.add(newTask)
+	l.lowered[hash] = taskArg{producer: newTask}
+	return l.lowered[hash]
 }
 
-func (e *Expression) generateId(operatorCount int) int {
-	return pkg.Pair(e.ID, operatorCount)
+// lowerConditional разбивает на задачи условие pkg.Conditional. Если результат условия уже известен, сразу
+// разбивается выбранная ветвь. Иначе создаётся задача-условие: агентам она не отправляется и в Tasks не попадает, а
+// ветвь выбирается, когда придёт результат условия (см. resolveConditional). Невыбранная ветвь задач не получает.
+func (e *Expression) lowerConditional(n *pkg.CallNode, id int, hash pkg.Hash) taskArg {
+	var condition = e.lower(n.Args[0])
+	if condition.producer == nil {
+		return e.lower(chooseBranch(n.Args[1:], condition.value))
+	}
+	var conditional = &Task{PairID: id, Operation: pkg.Conditional, Args: make([]interface{}, 2),
+		Status: ReadyToCalc, hash: hash, branches: n.Args[1:]}
+	conditional.bindArg(conditionSlot, condition)
+	return taskArg{producer: conditional}
 }
 
-func (e *Expression) getOperationTime(currentOperator string) (result time.Duration) {
-	var (
-		operatorAndEnvNamePairs = map[string]string{"+": TIME_ADDITION_MS, "-": TIME_SUBTRACTION_MS,
-			"*": TIME_MULTIPLICATIONS_MS, "/": TIME_DIVISIONS_MS}
-		maybeDuration string
-		err           error
-	)
-	for operator, envName := range operatorAndEnvNamePairs {
-		if currentOperator == operator {
-			maybeDuration = os.Getenv(envName)
-			if maybeDuration == "" {
-				log.Printf("WARNING: переменная %s не обнаружена", envName)
-			}
-			result, err = time.ParseDuration(maybeDuration)
-			if err != nil {
-				log.Panic(err)
-			}
+// chooseBranch возвращает первую ветвь, если условие истинно, и вторую, если ложно.
+func chooseBranch(branches []pkg.Node, condition interface{}) pkg.Node {
+	value, ok := condition.(bool)
+	if !ok { // результаты агентов проверяются в writeValueIntoTask.
+		log.Panicf("условие if имеет значение %v вместо логического", condition)
+	}
+	if value {
+		return branches[0]
+	}
+	return branches[1]
+}
+
+// resolveConditional записывает в задачу-условие результат её аргумента slot. По результату условия разбивается на
+// задачи выбранная ветвь, а результат ветви становится результатом задачи-условия. Вызывается под lowering.mut.
+func (e *Expression) resolveConditional(conditional *Task, slot int, value interface{}) {
+	if slot == conditionSlot {
+		var branch = e.lower(chooseBranch(conditional.branches, value))
+		if branch.producer != nil {
+			conditional.bindArg(branchSlot, branch)
+			return
 		}
+		value = branch.value
+	}
+	conditional.complete(value)
+	e.memo.Put(conditional.hash, value)
+	e.passResult(conditional)
+}
+
+// passResult передаёт результат посчитанной задачи задачам, которые его ждут; результат корня графа становится
+// результатом выражения. Задача, у которой больше нет непосчитанных аргументов, ставится в очередь готовых.
+// Вызывается под lowering.mut.
+func (e *Expression) passResult(task *Task) {
+	if task.IsRoot() {
+		e.finish(task.result)
+		return
+	}
+	for _, consumer := range task.consumers {
+		if consumer.task.IsConditional() {
+			e.resolveConditional(consumer.task, consumer.slot, task.result)
+		} else if consumer.task.writeArg(consumer.slot, task.result) {
+			e.tasksHandler.pushReady(consumer.task)
+		}
+	}
+}
+
+// finish записывает результат выражения и переводит его в статус Completed.
+func (e *Expression) finish(result interface{}) {
+	// Как и агент, не возвращаем бесконечность результатом: её не передать в JSON.
+	if value, ok := result.(float64); ok && math.IsInf(value, 0) {
+		e.markFailed(ErrorDetailsFabric(OverflowCode))
+		return
+	}
+	e.writeResult(result)
+	e.changeStatus(Completed)
+}
+
+// encodeNumber возвращает значение числа в режиме точности выражения. Числа проверены pkg.CheckPrecision при
+// отправке выражения, поэтому ошибка означает повреждённый журнал.
+func (e *Expression) encodeNumber(number *pkg.NumberNode) interface{} {
+	if e.Precision.IsFloat64() {
+		return number.Value
+	}
+	if e.Precision == pkg.Complex {
+		return complex(number.Value, number.Imag)
+	}
+	var value, err = e.Precision.EncodeNumber(number)
+	if err != nil {
+		log.Panicf("число %s нельзя посчитать в режиме %s: %s", pkg.Format(number), e.Precision, err)
+	}
+	return value
+}
+
+// getMemoKey возвращает ключ MemoCache для поддерева с отпечатком hash. Результат зависит от режима точности, поэтому
+// в точных режимах он входит в ключ.
+func (e *Expression) getMemoKey(hash pkg.Hash) pkg.Hash {
+	if e.Precision.IsFloat64() {
+		return hash
+	}
+	return sha256.Sum256(fmt.Appendf(hash[:], " %s %d", e.Precision, e.Bits))
+}
+
+// countOperations записывает в operations число операторов и вызовов функций в каждом поддереве node, а в offsets —
+// номер первого из них в постфиксе всего дерева; постфикс node начинается с оператора номер start.
+func countOperations(node pkg.Node, start int, operations, offsets map[pkg.Node]int) (count int) {
+	var children []pkg.Node
+	switch n := node.(type) {
+	case *pkg.UnaryNode:
+		children = []pkg.Node{n.Operand}
+	case *pkg.BinaryNode:
+		children = []pkg.Node{n.Left, n.Right}
+	case *pkg.CallNode:
+		children = n.Args
+	}
+	for _, child := range children {
+		count += countOperations(child, start+count, operations, offsets)
+	}
+	if len(children) > 0 { // у оператора и вызова функции всегда есть операнды.
+		count++
 	}
+	operations[node], offsets[node] = count, start
 	return
 }
 
+func (e *Expression) generateId(operatorCount int) int {
+	return pkg.Pair(e.ID, operatorCount)
+}
+
+func (e *Expression) getOperationTime(currentOperator string) time.Duration {
+	return e.operationTimes[operationTimeEnvNames[currentOperator]]
+}
+
 func (e *Expression) FabricReadyExprSendTask() TaskToSend {
-	maybeReadyTask := e.tasksHandler.registerFirst()
-	if maybeReadyTask.IsReadyToCalc() {
-		if e.tasksHandler.Len() == 1 {
-			e.changeStatus(NoReadyTasks)
-		} else {
-			e.changeStatus(Ready)
+	readyTask := e.tasksHandler.registerReady()
+	e.refreshStatus()
+	if readyTask == nil {
+		return TaskToSend{}
+	}
+	var timeAtSendingTask = time.Now()
+	return e.tasksHandler.TaskToSendFabricAdd(readyTask, timeAtSendingTask,
+		timeAtSendingTask.Add(e.getLeaseTime(readyTask)))
+}
+
+func (e *Expression) getLeaseTime(task *Task) time.Duration {
+	return task.OperationTime + e.leasePolicy.Grace
+}
+
+// ReapExpiredTasks возвращает в очередь задачи, аренда которых истекла к моменту now. Задача, исчерпавшая
+// LeasePolicy.MaxRetries повторных отправок, переводит выражение в статус Failed.
+func (e *Expression) ReapExpiredTasks(now time.Time) {
+	var expiredTasks = e.tasksHandler.popExpired(now)
+	if len(expiredTasks) == 0 {
+		return
+	}
+	var tasks = make([]*Task, 0, len(expiredTasks))
+	for _, expired := range expiredTasks {
+		var task = expired.Task
+		if task.getAttempts() > e.leasePolicy.MaxRetries {
+			var reason = TimeoutExecution{leaseTime: e.getLeaseTime(task), attempts: task.getAttempts(),
+				operation: task.Operation, pairId: task.PairID}
+			log.Println(reason)
+			e.fail(ErrorDetails{Code: TaskTimeoutCode, Message: reason.Error()})
+			return
 		}
-		taskToSend := e.tasksHandler.TaskToSendFabricAdd(maybeReadyTask, time.Now())
-		return taskToSend
+		tasks = append(tasks, task)
+	}
+	e.tasksHandler.requeue(tasks)
+	e.refreshStatus()
+}
+
+// refreshStatus выставляет Ready или NoReadyTasks в зависимости от наличия готовых задач. Проверка и запись
+// статуса происходят под одной блокировкой, чтобы параллельные запросы агентов не оставили устаревший статус.
+func (e *Expression) refreshStatus() {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.isFinished() {
+		return
+	}
+	if e.tasksHandler.ReadyLen() > 0 {
+		e.Status = Ready
 	} else {
-		e.changeStatus(NoReadyTasks)
-		return TaskToSend{}
+		e.Status = NoReadyTasks
 	}
 }
 
@@ -183,18 +688,61 @@ func (e *Expression) changeStatus(status ExprStatus) {
 	if e.Status == status {
 		return
 	}
-	if e.Status != Completed && e.Status != Cancelled {
+	if !e.isFinished() {
 		e.Status = status
 	} else {
 		log.Printf("попытка изменения статуса выражения %d, когда его статус %v", e.ID, e.Status)
 	}
 }
 
+// getStatus читает статус под e.mut: статус меняют агенты и сборщик просроченных задач.
+func (e *Expression) getStatus() ExprStatus {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.Status
+}
+
+// isFinished сообщает, что статус выражения окончательный и больше не меняется. Вызывается под e.mut.
+func (e *Expression) isFinished() bool {
+	return e.Status == Completed || e.Status == Cancelled || e.Status == Failed
+}
+
 func (e *Expression) Marshal() (result []byte, err error) {
 	result, err = json.Marshal(&e)
 	return
 }
 
+// MarshalJSON записывает результат выражения режима complex парой Complex: "result": {"re": 3, "im": 4}, а
+// логический результат — true или false: "result": true. Поля копируются под e.mut: агенты записывают результат,
+// пока клиент читает список выражений.
+func (e *Expression) MarshalJSON() ([]byte, error) {
+	e.mut.Lock()
+	var snapshot = struct {
+		ID          int                `json:"id"`
+		Source      string             `json:"source,omitempty"`
+		Status      ExprStatus         `json:"status"`
+		Result      interface{}        `json:"result"`
+		Value       string             `json:"value,omitempty"`
+		Fraction    string             `json:"fraction,omitempty"`
+		Precision   pkg.Precision      `json:"precision,omitempty"`
+		Bits        uint               `json:"bits,omitempty"`
+		Error       *ErrorDetails      `json:"error,omitempty"`
+		Variables   map[string]float64 `json:"variables,omitempty"`
+		TasksSaved  int                `json:"tasksSaved,omitempty"`
+		TasksReused int                `json:"tasksReused,omitempty"`
+	}{ID: e.ID, Source: e.Source, Status: e.Status, Result: e.Result, Value: e.Value, Fraction: e.Fraction,
+		Precision: e.Precision, Bits: e.Bits, Error: e.Error, Variables: e.Variables, TasksSaved: e.TasksSaved,
+		TasksReused: e.TasksReused}
+	switch {
+	case e.Boolean != nil:
+		snapshot.Result = *e.Boolean
+	case e.Precision == pkg.Complex:
+		snapshot.Result = Complex{Re: e.Result, Im: e.Imag}
+	}
+	e.mut.Unlock()
+	return json.Marshal(snapshot)
+}
+
 func (e *Expression) MarshalID() (result []byte, err error) {
 	result, err = json.Marshal(&struct {
 		ID int `json:"id"`
@@ -202,39 +750,267 @@ func (e *Expression) MarshalID() (result []byte, err error) {
 	return
 }
 
-func (e *Expression) WriteResultIntoTask(taskID int, result int64, timeAtReceiveTask time.Time) (err error) {
-	task, timeAtSendingTask, ok := e.tasksHandler.popSentTask(taskID)
-	if !ok {
-		return TaskIDNotExist{taskID}
+// WriteResultIntoTask записывает результат отправленной задачи. Если задача уже не числится отправленной (аренда
+// истекла или результат уже записан), возвращается LateTaskResult и состояние выражения не меняется.
+// Результат сравнения или логического оператора записывает WriteBooleanResultIntoTask.
+func (e *Expression) WriteResultIntoTask(taskID int, result float64) (err error) {
+	if !e.Precision.IsFloat64() {
+		return InvalidTaskResult{taskId: taskID, value: pkg.FormatNumber(result)}
+	}
+	return e.writeValueIntoTask(taskID, result)
+}
+
+// WriteBooleanResultIntoTask записывает результат задачи сравнения или логического оператора.
+func (e *Expression) WriteBooleanResultIntoTask(taskID int, result bool) (err error) {
+	return e.writeValueIntoTask(taskID, result)
+}
+
+// WriteComplexResultIntoTask записывает результат задачи выражения в режиме complex. Отсутствующий или бесконечный
+// результат даёт InvalidTaskResult.
+func (e *Expression) WriteComplexResultIntoTask(taskID int, result *Complex) (err error) {
+	if e.Precision != pkg.Complex || result == nil {
+		return InvalidTaskResult{taskId: taskID, value: fmt.Sprint(result)}
+	}
+	var value = result.Value()
+	if cmplx.IsInf(value) || cmplx.IsNaN(value) {
+		return InvalidTaskResult{taskId: taskID, value: fmt.Sprint(value)}
 	}
-	if factTime := timeAtReceiveTask.Sub(timeAtSendingTask); factTime > task.OperationTime {
-		e.changeStatus(Cancelled)
-		return TimeoutExecution{task.OperationTime, factTime, task.Operation,
-			task.PairID}
+	return e.writeValueIntoTask(taskID, value)
+}
+
+// WriteExactResultIntoTask записывает точный результат задачи выражения в точном режиме. Результат, который не
+// является записью числа этого режима, даёт InvalidTaskResult; дробь сокращается.
+func (e *Expression) WriteExactResultIntoTask(taskID int, value string) (err error) {
+	if !e.Precision.IsExact() {
+		return InvalidTaskResult{taskId: taskID, value: value}
+	}
+	normalized, err := pkg.NormalizeExact(e.Precision, value)
+	if err != nil {
+		return InvalidTaskResult{taskId: taskID, value: value}
+	}
+	return e.writeValueIntoTask(taskID, normalized)
+}
+
+// writeValueIntoTask записывает результат отправленной задачи и передаёт его дальше по графу. Логический результат
+// принимается только от сравнений и логических операторов, числовой — только от остальных задач.
+func (e *Expression) writeValueIntoTask(taskID int, result interface{}) (err error) {
+	var _, isBoolean = result.(bool)
+	if task := e.tasksHandler.getById(taskID); task != nil && pkg.ReturnsBoolean(task.Operation) != isBoolean {
+		return InvalidTaskResult{taskId: taskID, value: fmt.Sprint(result)}
+	}
+	task, ok := e.popSentTask(taskID)
+	if !ok {
+		return e.getMissingTaskError(taskID)
 	}
 	err = task.WriteResult(result)
 	if err != nil {
 		log.Panic(err)
 	}
-	e.tasksHandler.CountUpdatedTask()
-	//if tasksHandler.UpdatedAll
-	if e.tasksHandler.Len() == 1 {
-		e.changeStatus(Completed)
-		e.writeResult(task.result)
+	e.memo.Put(task.hash, result)
+	defer e.persist()
+	e.lowering.mut.Lock()
+	e.passResult(task)
+	e.lowering.mut.Unlock()
+	e.refreshStatus()
+	return
+}
+
+// FailTask снимает отправленную задачу, вычисление которой завершилось ошибкой на стороне агента, и переводит
+// выражение в статус Failed с причиной reason. Остальные задачи выражения больше не раздаются.
+func (e *Expression) FailTask(taskID int, reason ErrorDetails) (err error) {
+	task, ok := e.popSentTask(taskID)
+	if !ok {
+		return e.getMissingTaskError(taskID)
+	}
+	task.ChangeStatus(Calculated)
+	e.fail(reason)
+	return
+}
+
+func (e *Expression) popSentTask(taskID int) (*Task, bool) {
+	taskToSend, ok := e.tasksHandler.popSentTask(taskID)
+	return taskToSend.Task, ok
+}
+
+// getMissingTaskError отличает задачу, которой в выражении нет, от задачи, результат по которой опоздал.
+func (e *Expression) getMissingTaskError(taskID int) error {
+	if e.tasksHandler.getById(taskID) == nil {
+		return TaskIDNotExist{taskID}
+	}
+	return LateTaskResult{taskID}
+}
+
+// fail переводит выражение в статус Failed с причиной reason. Остальные задачи выражения больше не раздаются.
+func (e *Expression) fail(reason ErrorDetails) {
+	if e.markFailed(reason) {
+		e.persist()
+	}
+}
+
+// markFailed — fail без сохранения в хранилище. Возвращает false, если статус выражения уже окончательный.
+func (e *Expression) markFailed(reason ErrorDetails) bool {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	if e.isFinished() {
+		return false
+	}
+	e.Status = Failed
+	e.Error = &reason
+	return true
+}
+
+// persist сохраняет снимок выражения в хранилище. Снимок снимается под persistMut, поэтому при параллельных
+// изменениях последним в хранилище окажется самый свежий.
+func (e *Expression) persist() {
+	if e.storage == nil {
+		return
+	}
+	e.persistMut.Lock()
+	defer e.persistMut.Unlock()
+	if err := e.storage.Save(e.snapshot()); err != nil {
+		log.Printf("не удалось сохранить выражение %d: %s", e.ID, err)
+	}
+}
+
+func (e *Expression) snapshot() (record ExpressionRecord) {
+	e.mut.Lock()
+	record = ExpressionRecord{ID: e.ID, Source: e.Source, Tree: &pkg.NodeJson{Node: e.tree}, Status: e.Status,
+		Result: e.Result, Value: e.Value, Fraction: e.Fraction, Imag: e.Imag, Boolean: e.Boolean,
+		Precision: e.Precision, Bits: e.Bits, Error: e.Error, Variables: e.Variables, TasksSaved: e.TasksSaved,
+		TasksReused: e.TasksReused}
+	e.mut.Unlock()
+	if e.lowering == nil {
+		return
+	}
+	var results = e.tasksHandler.getCalculatedResults()
+	e.lowering.mut.Lock()
+	maps.Copy(results, e.knownResults)
+	e.lowering.mut.Unlock()
+	for id, result := range results {
+		switch value := result.(type) {
+		case bool:
+			if record.BooleanResults == nil {
+				record.BooleanResults = make(map[int]bool)
+			}
+			record.BooleanResults[id] = value
+		case float64:
+			if record.TaskResults == nil {
+				record.TaskResults = make(map[int]float64)
+			}
+			record.TaskResults[id] = value
+		case string:
+			if record.ExactResults == nil {
+				record.ExactResults = make(map[int]string)
+			}
+			record.ExactResults[id] = value
+		case complex128:
+			if record.ComplexResults == nil {
+				record.ComplexResults = make(map[int]Complex)
+			}
+			record.ComplexResults[id] = ComplexFabric(value)
+		}
 	}
 	return
 }
 
-func (e *Expression) writeResult(result int64) {
+// getRecordPrecision возвращает режим точности из снимка; в журналах, записанных до появления режимов, его нет.
+func getRecordPrecision(record ExpressionRecord) pkg.Precision {
+	if record.Precision == "" {
+		return pkg.Float64
+	}
+	return record.Precision
+}
+
+// restoreExpression восстанавливает выражение из снимка. Для незавершённого выражения граф задач строится заново, и
+// поддеревья с сохранёнными результатами сразу становятся числами, так что агентам повторно раздаются только
+// непосчитанные задачи.
+func restoreExpression(record ExpressionRecord, storage ExpressionStorage, leasePolicy LeasePolicy,
+	operationTimes OperationTimes) (*Expression, error) {
+	var tree pkg.Node
+	if record.Tree != nil {
+		tree = record.Tree.Node
+	} else {
+		var err error
+		if tree, err = pkg.ParsePostfix(record.Postfix); err != nil {
+			return nil, fmt.Errorf("выражение %d: %w", record.ID, err)
+		}
+	}
+	var expr = &Expression{tree: tree, ID: record.ID, Source: record.Source, Status: record.Status,
+		Result: record.Result, Value: record.Value, Fraction: record.Fraction, Imag: record.Imag,
+		Boolean: record.Boolean, Precision: getRecordPrecision(record), Bits: record.Bits, Error: record.Error,
+		Variables: record.Variables, TasksSaved: record.TasksSaved, TasksReused: record.TasksReused,
+		tasksHandler: TasksFabric(), leasePolicy: leasePolicy, operationTimes: operationTimes, storage: storage}
+	if expr.isFinished() {
+		return expr, nil
+	}
+	var known = make(map[int]interface{})
+	for id, result := range record.TaskResults {
+		known[id] = result
+	}
+	for id, result := range record.ExactResults {
+		known[id] = result
+	}
+	for id, result := range record.ComplexResults {
+		known[id] = result.Value()
+	}
+	for id, result := range record.BooleanResults {
+		known[id] = result
+	}
+	expr.divideIntoTasks(known)
+	expr.refreshStatus()
+	return expr, nil
+}
+
+// writeResult записывает результат выражения; точный результат дополнительно переводится в десятичную запись.
+func (e *Expression) writeResult(result interface{}) {
 	e.mut.Lock()
 	defer e.mut.Unlock()
-	e.Result = result
+	switch value := result.(type) {
+	case float64:
+		e.Result = value
+	case string:
+		text, approx, err := pkg.FormatExact(e.Precision, e.Bits, value)
+		if err != nil { // результаты агентов проверяются в WriteExactResultIntoTask.
+			log.Panic(err)
+		}
+		e.Result, e.Value = approx, text
+		if e.Precision == pkg.Rational {
+			e.Fraction = value
+		}
+	case complex128:
+		e.Result, e.Imag = real(value), imag(value)
+	case bool:
+		e.Boolean = &value
+	}
 }
 
 func (e *Expression) GetTasksHandler() *Tasks {
 	return e.tasksHandler
 }
 
+// GetTree возвращает дерево выражения. Дерево не меняется после создания выражения, поэтому блокировка не нужна.
+func (e *Expression) GetTree() pkg.Node {
+	return e.tree
+}
+
+// ExpressionAstJson — ответ GET /api/v1/expressions/{ID}/ast: дерево, в котором вместо переменных уже стоят их
+// значения, и то же дерево в каноническом виде со скобками только там, где они нужны.
+type ExpressionAstJson struct {
+	ID        int          `json:"id"`
+	Source    string       `json:"source"`
+	Canonical string       `json:"canonical"`
+	Tree      pkg.NodeJson `json:"tree"`
+}
+
+type ExpressionAstJsonTitle struct {
+	Ast ExpressionAstJson `json:"ast"`
+}
+
+func (e ExpressionAstJsonTitle) Marshal() (result []byte, err error) {
+	result, err = json.Marshal(&e)
+	return
+}
+
 type ExpressionsJsonTitle struct {
 	Expressions []*Expression `json:"expressions"`
 }
@@ -266,29 +1042,152 @@ type Task struct {
 	PairID        int           `json:"id"`
 	Arg1          interface{}   `json:"arg1"`
 	Arg2          interface{}   `json:"arg2"`
+	Args          []interface{} `json:"args,omitempty"` // аргументы вызова функции; у операторов не используется.
 	Operation     string        `json:"operation"`
 	OperationTime time.Duration `json:"operationTime"`
-	result        int64
-	Status        TaskStatus
+	Precision     pkg.Precision `json:"precision,omitempty"` // только для точных режимов; аргументы тогда — строки.
+	Bits          uint          `json:"bits,omitempty"`
+	result        interface{}
+	attempts      int        // сколько раз задача была отправлена агентам.
+	Status        TaskStatus `json:"-"`
+	consumers     []taskSlot // аргументы задач, в которые запишется результат этой. У корня графа — пусто.
+	hash          pkg.Hash   // отпечаток поддерева, ключ результата в MemoCache.
+	branches      []pkg.Node // ветви задачи-условия pkg.Conditional, см. Expression.lowerConditional.
+	waitingArgs   int        // число аргументов, которые ещё ожидают результатов дочерних задач.
 	mut           sync.Mutex
 }
 
+// taskSlot — аргумент slot задачи task: 0 — Arg1, 1 — Arg2; у функций — индекс в Args. Одна задача может быть
+// аргументом нескольких, если в выражении есть одинаковые поддеревья.
+type taskSlot struct {
+	task *Task
+	slot int
+}
+
 func (t *Task) Marshal() (result []byte, err error) {
-	result, err = json.Marshal(&struct { // нужно отфильтровать публичные атрибуты (t.Status), поскольку
-		// Marshal их распарсит, даже несмотря на отсутствие дополнительного поля формата `json:""`.
-		PairID        int           `json:"id"`
-		Arg1          interface{}   `json:"arg1"`
-		Arg2          interface{}   `json:"arg2"`
-		Operation     string        `json:"operation"`
-		OperationTime time.Duration `json:"operationTime"`
-	}{t.PairID, t.Arg1, t.Arg2, t.Operation, t.OperationTime})
+	result, err = json.Marshal(t)
 	if err != nil {
 		log.Panic(err)
 	}
 	return
 }
 
-func (t *Task) WriteResult(result int64) error {
+// taskJson — Task в том виде, в каком он передаётся агенту: без служебных полей (t.Status и т.д.).
+type taskJson struct {
+	PairID        int           `json:"id"`
+	Arg1          interface{}   `json:"arg1"`
+	Arg2          interface{}   `json:"arg2"`
+	Args          []interface{} `json:"args,omitempty"`
+	Operation     string        `json:"operation"`
+	OperationTime time.Duration `json:"operationTime"`
+	Precision     pkg.Precision `json:"precision,omitempty"`
+	Bits          uint          `json:"bits,omitempty"`
+}
+
+// MarshalJSON записывает бесконечные аргументы (например, константу inf) строками "+Inf" и "-Inf": в JSON нет
+// чисел для них, а аргументы режимов rational и complex — парами Fraction и Complex. UnmarshalJSON превращает их
+// обратно в значения.
+func (t *Task) MarshalJSON() ([]byte, error) {
+	var args []interface{}
+	for _, arg := range t.Args {
+		args = append(args, t.encodeArg(arg))
+	}
+	return json.Marshal(taskJson{PairID: t.PairID, Arg1: t.encodeArg(t.Arg1), Arg2: t.encodeArg(t.Arg2), Args: args,
+		Operation: t.Operation, OperationTime: t.OperationTime, Precision: t.Precision, Bits: t.Bits})
+}
+
+func (t *Task) UnmarshalJSON(buf []byte) (err error) {
+	var decoded taskJson
+	if err = json.Unmarshal(buf, &decoded); err != nil {
+		return
+	}
+	t.PairID, t.Operation, t.OperationTime = decoded.PairID, decoded.Operation, decoded.OperationTime
+	t.Precision, t.Bits = decoded.Precision, decoded.Bits
+	if t.Arg1, err = t.decodeArg(decoded.Arg1); err != nil {
+		return
+	}
+	if t.Arg2, err = t.decodeArg(decoded.Arg2); err != nil {
+		return
+	}
+	t.Args = nil
+	for _, arg := range decoded.Args {
+		var value interface{}
+		if value, err = t.decodeArg(arg); err != nil {
+			return
+		}
+		t.Args = append(t.Args, value)
+	}
+	return
+}
+
+func (t *Task) encodeArg(arg interface{}) interface{} {
+	switch value := arg.(type) {
+	case float64:
+		if math.IsInf(value, 0) {
+			return pkg.FormatNumber(value)
+		}
+	case string:
+		if t.Precision == pkg.Rational {
+			fraction, err := FractionFabric(value)
+			if err != nil { // в задачи попадают только проверенные значения.
+				log.Panic(err)
+			}
+			return fraction
+		}
+	case complex128:
+		return ComplexFabric(value)
+	}
+	return arg
+}
+
+// decodeArg переводит строку "+Inf" или "-Inf" обратно в число, пару Fraction режима rational — в запись дроби, а
+// пару Complex — в complex128. В остальных точных режимах строки — сами значения и остаются строками.
+func (t *Task) decodeArg(arg interface{}) (interface{}, error) {
+	if t.Precision == pkg.Complex && arg != nil {
+		var value Complex
+		if err := remarshal(arg, &value); err != nil {
+			return nil, err
+		}
+		return value.Value(), nil
+	}
+	if t.Precision == pkg.Rational && arg != nil {
+		var fraction Fraction
+		if err := remarshal(arg, &fraction); err != nil {
+			return nil, err
+		}
+		return pkg.NormalizeExact(pkg.Rational, fraction.String())
+	}
+	if value, ok := arg.(string); ok && !t.Precision.IsExact() {
+		return strconv.ParseFloat(value, 64)
+	}
+	return arg, nil
+}
+
+// remarshal переводит значение, разобранное в interface{}, в структуру target.
+func remarshal(value interface{}, target interface{}) error {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, target)
+}
+
+// complete записывает результат задачи, которую оркестратор посчитал сам, не отправляя агентам.
+func (t *Task) complete(result interface{}) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.result = result
+	t.Status = Calculated
+}
+
+// getResult возвращает результат задачи; ok == false, если задача ещё не посчитана.
+func (t *Task) getResult() (result interface{}, ok bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.result, t.Status == Calculated && t.result != nil
+}
+
+func (t *Task) WriteResult(result interface{}) error {
 	t.mut.Lock()
 	defer t.mut.Unlock()
 	if t.Status == Sent {
@@ -301,6 +1200,47 @@ func (t *Task) WriteResult(result int64) error {
 	return nil
 }
 
+// bindArg записывает число в аргумент slot или, если аргумент — результат другой задачи, связывает её с этой.
+func (t *Task) bindArg(slot int, arg taskArg) {
+	if arg.producer != nil {
+		arg.producer.consumers = append(arg.producer.consumers, taskSlot{task: t, slot: slot})
+		t.waitingArgs++
+		t.Status = WaitingOtherTasks
+		return
+	}
+	t.setArg(slot, arg.value)
+}
+
+func (t *Task) setArg(slot int, value interface{}) {
+	if t.IsFunction() {
+		t.Args[slot] = value
+	} else if slot == 0 {
+		t.Arg1 = value
+	} else {
+		t.Arg2 = value
+	}
+}
+
+// writeArg записывает результат дочерней задачи в аргумент slot. Возвращает true, если это был последний
+// недостающий аргумент и задача стала ReadyToCalc.
+func (t *Task) writeArg(slot int, value interface{}) (becameReady bool) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	t.setArg(slot, value)
+	t.waitingArgs--
+	if t.waitingArgs == 0 && t.Status == WaitingOtherTasks {
+		t.Status = ReadyToCalc
+		becameReady = true
+	}
+	return
+}
+
+func (t *Task) getAttempts() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.attempts
+}
+
 func (t *Task) ChangeStatus(newStatus TaskStatus) {
 	t.mut.Lock()
 	defer t.mut.Unlock()
@@ -316,9 +1256,81 @@ func (t *Task) IsReadyToCalc() bool {
 	return t.Status == ReadyToCalc
 }
 
+// IsRoot сообщает, что результат задачи является результатом всего выражения.
+func (t *Task) IsRoot() bool {
+	return len(t.consumers) == 0
+}
+
+// IsConditional сообщает, что задача — условие pkg.Conditional, которое считает сам оркестратор.
+func (t *Task) IsConditional() bool {
+	return t.Operation == pkg.Conditional
+}
+
+// IsUnary сообщает, что задача принимает только Arg1.
+func (t *Task) IsUnary() bool {
+	return pkg.IsUnaryOperator(t.Operation)
+}
+
+// IsFunction сообщает, что задача — вызов функции, и её аргументы лежат в Args.
+func (t *Task) IsFunction() bool {
+	_, ok := pkg.GetFunctionArity(t.Operation)
+	return ok
+}
+
 type AgentResult struct {
-	ID     int   `json:"ID"`
-	Result int64 `json:"result"`
+	ID       int           `json:"ID"`
+	Result   float64       `json:"result"`
+	Value    string        `json:"value,omitempty"`    // точный результат задачи в режимах int64 и big; Result — его приближение.
+	Fraction *Fraction     `json:"fraction,omitempty"` // точный результат задачи в режиме rational.
+	Complex  *Complex      `json:"complex,omitempty"`  // результат задачи в режиме complex; Result — его вещественная часть.
+	Boolean  *bool         `json:"boolean,omitempty"`  // результат сравнения или логического оператора.
+	Error    *ErrorDetails `json:"error,omitempty"`    // заполняется, если задачу не удалось посчитать; Result тогда не используется.
+}
+
+// GetExactValue возвращает точный результат задачи режима precision в той записи, в которой значения хранятся в
+// задачах.
+func (a *AgentResult) GetExactValue(precision pkg.Precision) string {
+	if precision != pkg.Rational {
+		return a.Value
+	}
+	if a.Fraction == nil {
+		return ""
+	}
+	return a.Fraction.String()
+}
+
+// Fraction — рациональное число режима rational парой числитель/знаменатель. Обе части — строки, чтобы большие
+// числа не теряли точность в JSON; знаменатель положителен.
+type Fraction struct {
+	Num string `json:"num"`
+	Den string `json:"den"`
+}
+
+// FractionFabric создаёт Fraction из записи дроби ("1/3", "5") и сокращает её.
+func FractionFabric(value string) (result Fraction, err error) {
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return result, pkg.MalformedNumber
+	}
+	return Fraction{Num: rat.Num().String(), Den: rat.Denom().String()}, nil
+}
+
+func (f Fraction) String() string {
+	return f.Num + "/" + f.Den
+}
+
+// Complex — комплексное число режима complex парой вещественная/мнимая часть.
+type Complex struct {
+	Re float64 `json:"re"`
+	Im float64 `json:"im"`
+}
+
+func ComplexFabric(value complex128) Complex {
+	return Complex{Re: real(value), Im: imag(value)}
+}
+
+func (c Complex) Value() complex128 {
+	return complex(c.Re, c.Im)
 }
 
 func (a *AgentResult) Marshal() (result []byte, err error) {
@@ -0,0 +1,96 @@
+package backend
+
+import (
+	"container/list"
+	"encoding/json"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"sync"
+)
+
+// DefaultMemoCapacity — сколько результатов по умолчанию хранит MemoCache.
+const DefaultMemoCapacity = 10000
+
+// MemoCache — общий для всех выражений кэш результатов задач по отпечатку поддерева (pkg.Hash). Когда в новом
+// выражении встречается поддерево, которое уже было посчитано, его результат подставляется сразу, и задачи для него
+// не создаются. Кэшируются только успешные результаты; при переполнении вытесняется результат, который дольше всех
+// не использовался. nil-кэш и кэш нулевой ёмкости ничего не хранят и статистику не ведут.
+type MemoCache struct {
+	mut      sync.Mutex
+	capacity int
+	entries  map[pkg.Hash]*list.Element
+	order    *list.List // *memoEntry; в начале — последний использованный.
+	hits     int
+	misses   int
+}
+
+type memoEntry struct {
+	hash  pkg.Hash
+	value interface{} // float64 или точная запись-строка, как у результатов задач.
+}
+
+func MemoCacheFabric(capacity int) *MemoCache {
+	return &MemoCache{capacity: capacity, entries: make(map[pkg.Hash]*list.Element), order: list.New()}
+}
+
+// Get ищет результат поддерева и учитывает попадание или промах в статистике.
+func (m *MemoCache) Get(hash pkg.Hash) (value interface{}, ok bool) {
+	if m == nil || m.capacity <= 0 {
+		return
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	element, ok := m.entries[hash]
+	if !ok {
+		m.misses++
+		return
+	}
+	m.hits++
+	m.order.MoveToFront(element)
+	return element.Value.(*memoEntry).value, true
+}
+
+// Put запоминает результат поддерева.
+func (m *MemoCache) Put(hash pkg.Hash, value interface{}) {
+	if m == nil || m.capacity <= 0 {
+		return
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if element, ok := m.entries[hash]; ok {
+		element.Value.(*memoEntry).value = value
+		m.order.MoveToFront(element)
+		return
+	}
+	m.entries[hash] = m.order.PushFront(&memoEntry{hash: hash, value: value})
+	if m.order.Len() > m.capacity {
+		var oldest = m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoEntry).hash)
+	}
+}
+
+// Stats возвращает текущую статистику кэша.
+func (m *MemoCache) Stats() (result MemoStatsJson) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	return MemoStatsJson{Capacity: m.capacity, Size: m.order.Len(), Hits: m.hits, Misses: m.misses}
+}
+
+type MemoStatsJson struct {
+	Capacity int `json:"capacity"`
+	Size     int `json:"size"`
+	Hits     int `json:"hits"`   // поддеревья, результат которых был взят из кэша.
+	Misses   int `json:"misses"` // поддеревья, которые пришлось отдать агентам.
+}
+
+type MemoStatsJsonTitle struct {
+	Memo MemoStatsJson `json:"memo"`
+}
+
+func (m MemoStatsJsonTitle) Marshal() (result []byte, err error) {
+	result, err = json.Marshal(&m)
+	return
+}
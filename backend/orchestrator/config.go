@@ -1,7 +1,133 @@
 package main
 
-import "net/http"
+import (
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
 
-func GetDefaultServer(handler http.Handler) *http.Server {
-	return &http.Server{Addr: "127.0.0.1:8000", Handler: handler}
+const (
+	ORCHESTRATOR_CONFIG      = "ORCHESTRATOR_CONFIG"
+	ORCHESTRATOR_ADDR        = "ORCHESTRATOR_ADDR"
+	EXPRESSIONS_STORAGE_PATH = "EXPRESSIONS_STORAGE_PATH"
+	CONSTANTS_STORAGE_PATH   = "CONSTANTS_STORAGE_PATH"
+	ADMIN_TOKEN              = "ADMIN_TOKEN"
+	MEMO_CAPACITY            = "MEMO_CAPACITY"
+	LEASE_GRACE              = "LEASE_GRACE"
+	LEASE_MAX_RETRIES        = "LEASE_MAX_RETRIES"
+	LEASE_REAPER_INTERVAL    = "LEASE_REAPER_INTERVAL"
+	READ_TIMEOUT             = "READ_TIMEOUT"
+	WRITE_TIMEOUT            = "WRITE_TIMEOUT"
+	SHUTDOWN_TIMEOUT         = "SHUTDOWN_TIMEOUT"
+)
+
+// Config — параметры оркестратора. Заполняется LoadConfig из флагов, переменных среды и конфигурационного файла.
+type Config struct {
+	Addr                string
+	StoragePath         string // журнал выражений; по умолчанию создаётся в рабочей директории.
+	ConstantsPath       string // файл с константами, зарегистрированными через API.
+	AdminToken          string // токен для изменения констант; без него изменять константы нельзя (403).
+	MemoCapacity        int    // сколько результатов поддеревьев хранит кэш; 0 отключает кэш.
+	OperationTimes      backend.OperationTimes
+	LeasePolicy         backend.LeasePolicy
+	LeaseReaperInterval time.Duration // как часто проверяется аренда отправленных агентам задач.
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	ShutdownTimeout     time.Duration // сколько ждать завершения начатых запросов после сигнала остановки.
+}
+
+func ConfigDefaultFabric() *Config {
+	return &Config{
+		Addr:                "127.0.0.1:8000",
+		StoragePath:         "expressions.jsonl",
+		ConstantsPath:       "constants.json",
+		MemoCapacity:        backend.DefaultMemoCapacity,
+		OperationTimes:      backend.OperationTimesDefaultFabric(),
+		LeasePolicy:         backend.DefaultLeasePolicy,
+		LeaseReaperInterval: 500 * time.Millisecond,
+		ReadTimeout:         10 * time.Second,
+		WriteTimeout:        10 * time.Second,
+		ShutdownTimeout:     10 * time.Second,
+	}
+}
+
+func (c *Config) settings() []backend.Setting {
+	return append([]backend.Setting{
+		backend.StringSetting("addr", ORCHESTRATOR_ADDR, "адрес, на котором оркестратор принимает запросы",
+			&c.Addr),
+		backend.StringSetting("storage-path", EXPRESSIONS_STORAGE_PATH, "путь к журналу выражений",
+			&c.StoragePath),
+		backend.StringSetting("constants-path", CONSTANTS_STORAGE_PATH, "путь к файлу с именованными константами",
+			&c.ConstantsPath),
+		backend.StringSetting("admin-token", ADMIN_TOKEN,
+			"токен для изменения констант (заголовок Authorization: Bearer); без него константы изменить нельзя",
+			&c.AdminToken),
+		backend.IntSetting("memo-capacity", MEMO_CAPACITY,
+			"сколько результатов поддеревьев хранит общий кэш; 0 отключает кэш", &c.MemoCapacity),
+		backend.DurationSetting("lease-grace", LEASE_GRACE,
+			"запас времени сверх времени операции, после которого задача отправляется повторно", &c.LeasePolicy.Grace),
+		backend.IntSetting("lease-max-retries", LEASE_MAX_RETRIES,
+			"число повторных отправок задачи, после которого выражение считается ошибочным",
+			&c.LeasePolicy.MaxRetries),
+		backend.DurationSetting("lease-reaper-interval", LEASE_REAPER_INTERVAL,
+			"как часто проверяется аренда отправленных задач", &c.LeaseReaperInterval),
+		backend.DurationSetting("read-timeout", READ_TIMEOUT, "время на чтение HTTP-запроса", &c.ReadTimeout),
+		backend.DurationSetting("write-timeout", WRITE_TIMEOUT, "время на запись HTTP-ответа", &c.WriteTimeout),
+		backend.DurationSetting("shutdown-timeout", SHUTDOWN_TIMEOUT,
+			"сколько ждать завершения начатых запросов при остановке", &c.ShutdownTimeout),
+	}, c.OperationTimes.Settings()...)
+}
+
+// Validate проверяет параметры, чтобы оркестратор не запустился с заведомо неработающей конфигурацией.
+func (c *Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return errors.Join(errors.New("некорректный адрес addr"), err)
+	}
+	if c.StoragePath == "" {
+		return errors.New("не задан путь к журналу выражений storage-path")
+	}
+	if c.ConstantsPath == "" {
+		return errors.New("не задан путь к файлу констант constants-path")
+	}
+	if c.MemoCapacity < 0 {
+		return errors.New("memo-capacity не может быть отрицательным")
+	}
+	if c.LeasePolicy.Grace < 0 {
+		return errors.New("lease-grace не может быть отрицательным")
+	}
+	if c.LeasePolicy.MaxRetries < 0 {
+		return errors.New("lease-max-retries не может быть отрицательным")
+	}
+	if c.LeaseReaperInterval <= 0 {
+		return errors.New("lease-reaper-interval должен быть положительным")
+	}
+	if c.ReadTimeout < 0 || c.WriteTimeout < 0 {
+		return errors.New("read-timeout и write-timeout не могут быть отрицательными")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdown-timeout не может быть отрицательным")
+	}
+	return c.OperationTimes.Validate()
+}
+
+// LoadConfig читает конфигурацию из args (без имени программы), переменных среды и файла, путь к которому задан
+// флагом -config или переменной ORCHESTRATOR_CONFIG. Приоритет: флаг > переменная среды > файл > по умолчанию.
+func LoadConfig(args []string, output io.Writer) (config *Config, err error) {
+	config = ConfigDefaultFabric()
+	err = backend.LoadSettings("orchestrator", config.settings(), ORCHESTRATOR_CONFIG, args, output)
+	if err != nil {
+		return nil, err
+	}
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func GetServer(config *Config, handler http.Handler) *http.Server {
+	return &http.Server{Addr: config.Addr, Handler: handler, ReadTimeout: config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout}
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	var path = filepath.Join(t.TempDir(), "orchestrator.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearConfigEnv скрывает от теста переменные среды оркестратора (например, заданные в agent.env).
+func clearConfigEnv(t *testing.T) {
+	t.Setenv(ORCHESTRATOR_CONFIG, "")
+	for _, setting := range ConfigDefaultFabric().settings() {
+		t.Setenv(setting.Env, "")
+	}
+}
+
+func TestLoadConfigDefault(t *testing.T) {
+	clearConfigEnv(t)
+	config, err := LoadConfig(nil, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ConfigDefaultFabric(), config)
+}
+
+// TestLoadConfigPrecedence проверяет порядок источников: флаг > переменная среды > файл > значение по умолчанию.
+func TestLoadConfigPrecedence(t *testing.T) {
+	var path = writeConfigFile(t, "addr: 127.0.0.1:9000\n"+
+		"lease-max-retries: 7\n"+
+		"time-addition: 3s\n"+
+		"time-division: 4s\n")
+	clearConfigEnv(t)
+	t.Setenv(ORCHESTRATOR_CONFIG, path)
+	t.Setenv(ORCHESTRATOR_ADDR, "127.0.0.1:9001")
+	t.Setenv(backend.TIME_ADDITION_MS, "5s")
+	config, err := LoadConfig([]string{"-addr", "127.0.0.1:9002"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "127.0.0.1:9002", config.Addr)
+	assert.Equal(t, 7, config.LeasePolicy.MaxRetries)
+	assert.Equal(t, 5*time.Second, config.OperationTimes[backend.TIME_ADDITION_MS])
+	assert.Equal(t, 4*time.Second, config.OperationTimes[backend.TIME_DIVISIONS_MS])
+	assert.Equal(t, time.Second, config.OperationTimes[backend.TIME_MULTIPLICATIONS_MS])
+	assert.Equal(t, backend.DefaultLeasePolicy.Grace, config.LeasePolicy.Grace)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	var cases = []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+	}{
+		{name: "unparsable duration in env", env: map[string]string{backend.TIME_ADDITION_MS: "2"}},
+		{name: "unparsable int in flag", args: []string{"-lease-max-retries", "много"}},
+		{name: "negative operation time", args: []string{"-time-subtraction", "-1s"}},
+		{name: "zero reaper interval", args: []string{"-lease-reaper-interval", "0s"}},
+		{name: "negative memo capacity", env: map[string]string{MEMO_CAPACITY: "-1"}},
+		{name: "address without port", env: map[string]string{ORCHESTRATOR_ADDR: "localhost"}},
+		{name: "unknown key in file", file: "port: 8000\n"},
+		{name: "malformed file", file: "addr: [\n"},
+		{name: "unexpected argument", args: []string{"8000"}},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			for name, value := range testCase.env {
+				t.Setenv(name, value)
+			}
+			var args = testCase.args
+			if testCase.file != "" {
+				args = append(args, "-config", writeConfigFile(t, testCase.file))
+			}
+			config, err := LoadConfig(args, io.Discard)
+			assert.Error(t, err)
+			assert.Nil(t, config)
+		})
+	}
+}
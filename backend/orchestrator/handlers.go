@@ -1,50 +1,86 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"github.com/Debianov/calc-ya-go-24/backend"
 	"github.com/Debianov/calc-ya-go-24/pkg"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"slices"
 	"strconv"
-	"time"
+	"strings"
 )
 
-var exprsList = backend.ExpressionListEmptyFabric()
+var (
+	exprsList = backend.ExpressionListEmptyFabric()
+	constants = backend.ConstantsRegistryEmptyFabric()
+	// adminToken — токен для изменения констант. Если он пустой, изменять константы не может никто.
+	adminToken string
+)
 
 func calcHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		err error
 	)
-	if r.Method != http.MethodPost {
+	if !checkMethod(w, r, http.MethodPost) {
 		return
 	}
-	if r.Header.Get("Content-Type") != "application/json" {
+	if !checkJsonContentType(w, r) {
 		return
 	}
-	var (
-		buf           []byte
-		requestStruct backend.RequestJson
-		reader        io.ReadCloser
-	)
-	reader = r.Body
-	buf, err = io.ReadAll(reader)
-	if err != nil {
-		log.Panic(err)
+	var requestStruct backend.RequestJson
+	if !decodeJsonBody(w, r, &requestStruct) {
+		return
+	}
+	for name := range requestStruct.Variables {
+		if !pkg.IsVariableName(name) {
+			var errorJson = backend.ErrorJsonFabric(backend.InvalidVariableCode)
+			errorJson.Error.Token = name
+			writeError(w, http.StatusUnprocessableEntity, errorJson)
+			return
+		}
 	}
-	err = json.Unmarshal(buf, &requestStruct)
+	precision, bits, err := requestStruct.GetPrecision()
 	if err != nil {
-		log.Panic(err)
+		var errorJson = backend.ErrorJsonFabric(backend.InvalidPrecisionCode)
+		errorJson.Error.Token = requestStruct.Precision
+		writeError(w, http.StatusUnprocessableEntity, errorJson)
+		return
 	}
-	postfix, ok := pkg.GeneratePostfix(requestStruct.Expression)
-	if !ok {
-		w.WriteHeader(422)
+	tree, err := pkg.Parse(requestStruct.Expression)
+	if err == nil {
+		tree, err = pkg.Bind(tree, func(name string) (float64, bool) {
+			if value, ok := requestStruct.GetVariable(name); ok {
+				return value, true
+			}
+			return constants.Get(name)
+		})
+	}
+	if err == nil {
+		if requestStruct.Precision == "" && pkg.RequiresComplex(tree) { // 3+4i без указания режима.
+			precision = pkg.Complex
+		}
+		err = pkg.CheckPrecision(tree, precision)
+	}
+	if err != nil {
+		var parseError pkg.ParseError
+		if errors.As(err, &parseError) {
+			writeError(w, http.StatusUnprocessableEntity, backend.ParseErrorJsonFabric(parseError))
+		} else {
+			writeError(w, http.StatusUnprocessableEntity, backend.ErrorJsonFabric(backend.InvalidExpressionCode))
+		}
 		return
 	}
-	expr, _ := exprsList.ExprFabricAdd(postfix)
+	var input = backend.ExpressionInput{Source: requestStruct.Expression, Tree: tree,
+		Variables: requestStruct.Variables, Precision: precision, Bits: bits}
+	if requestStruct.Simplify {
+		input.Tree, input.TasksSaved = pkg.Simplify(tree, precision)
+	}
+	expr, _ := exprsList.ExprFabricAddInput(input)
 	marshaledExpr, err := expr.MarshalID()
 	if err != nil {
 		log.Panic(err)
@@ -57,7 +93,7 @@ func calcHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func expressionsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if !checkMethod(w, r, http.MethodGet) {
 		return
 	}
 	var err error
@@ -81,52 +117,86 @@ func expressionsHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func expressionIdHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if !checkMethod(w, r, http.MethodGet) {
 		return
 	}
 	var err error
-	id := r.PathValue("ID")
-	idInINt, err := strconv.ParseInt(id, 10, 64)
+	expr, ok := getExpressionByPath(w, r)
+	if !ok {
+		return
+	}
+	var exprJsonHandler = backend.ExpressionJsonTitle{Expression: expr}
+	exprHandlerInBytes, err := json.Marshal(&exprJsonHandler)
 	if err != nil {
 		log.Panic(err)
 	}
-	expr, exist := exprsList.Get(int(idInINt))
-	if !exist {
-		w.WriteHeader(404)
+	_, err = w.Write(exprHandlerInBytes)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func expressionAstHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
 		return
 	}
-	var exprJsonHandler = backend.ExpressionJsonTitle{expr}
-	exprHandlerInBytes, err := json.Marshal(&exprJsonHandler)
+	expr, ok := getExpressionByPath(w, r)
+	if !ok {
+		return
+	}
+	var (
+		tree    = expr.GetTree()
+		astJson = backend.ExpressionAstJsonTitle{Ast: backend.ExpressionAstJson{ID: expr.ID, Source: expr.Source,
+			Canonical: pkg.Format(tree), Tree: pkg.NodeJson{Node: tree}}}
+	)
+	buf, err := astJson.Marshal()
 	if err != nil {
 		log.Panic(err)
 	}
-	_, err = w.Write(exprHandlerInBytes)
+	_, err = w.Write(buf)
 	if err != nil {
 		log.Panic(err)
 	}
 }
 
+// getExpressionByPath находит выражение по ID из пути запроса. Если ID некорректный или выражения нет, сам
+// отвечает ошибкой.
+func getExpressionByPath(w http.ResponseWriter, r *http.Request) (expr *backend.Expression, ok bool) {
+	id, err := strconv.ParseInt(r.PathValue("ID"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, backend.ErrorJsonFabric(backend.InvalidIdCode))
+		return nil, false
+	}
+	expr, ok = exprsList.Get(int(id))
+	if !ok {
+		writeError(w, http.StatusNotFound, backend.ErrorJsonFabric(backend.ExpressionNotFoundCode))
+	}
+	return
+}
+
 func taskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		taskGetHandler(w, r)
 	} else if r.Method == http.MethodPost {
 		taskPostHandler(w, r)
+	} else {
+		checkMethod(w, r, http.MethodGet, http.MethodPost)
 	}
 }
 
 func taskGetHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+	if !checkMethod(w, r, http.MethodGet) {
 		return
 	}
 	var err error
 	expr := exprsList.GetReadyExpr()
 	if expr == nil {
-		w.WriteHeader(404)
+		writeError(w, http.StatusNotFound, backend.ErrorJsonFabric(backend.NoReadyTasksCode))
 		return
 	}
 	responseInJson := expr.FabricReadyExprSendTask()
 	if responseInJson.Task == nil {
-		w.WriteHeader(404)
+		writeError(w, http.StatusNotFound, backend.ErrorJsonFabric(backend.NoReadyTasksCode))
 		return
 	}
 	taskJsonHandlerInBytes, err := responseInJson.Marshal()
@@ -137,48 +207,247 @@ func taskGetHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		log.Panic(err)
 	}
-	responseInJson.Task.ChangeStatus(backend.Sent)
 }
 
 func taskPostHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
+	if !checkMethod(w, r, http.MethodPost) {
 		return
 	}
-	if r.Header.Get("Content-Type") != "application/json" {
+	if !checkJsonContentType(w, r) {
 		return
 	}
 	var (
-		err    error
-		reqBuf = make([]byte, r.ContentLength)
-	)
-	_, err = r.Body.Read(reqBuf)
-	if err != nil && err != io.EOF {
-		log.Panic(err)
-	}
-	var (
+		err       error
 		reqInJson backend.AgentResult
 	)
-	err = json.Unmarshal(reqBuf, &reqInJson)
-	if err != nil {
-		log.Panic(err)
-		//w.WriteHeader(422) // TODO проверка структуры
+	if !decodeJsonBody(w, r, &reqInJson) {
+		return
 	}
 	exprId, _ := pkg.Unpair(reqInJson.ID)
 	expr, ok := exprsList.Get(exprId)
 	if !ok {
-		w.WriteHeader(404)
+		writeError(w, http.StatusNotFound, backend.ErrorJsonFabric(backend.ExpressionNotFoundCode))
 		return
 	}
-	err = expr.WriteResultIntoTask(reqInJson.ID, reqInJson.Result, time.Now())
+	switch {
+	case reqInJson.Error != nil:
+		err = expr.FailTask(reqInJson.ID, *reqInJson.Error)
+	case reqInJson.Boolean != nil:
+		err = expr.WriteBooleanResultIntoTask(reqInJson.ID, *reqInJson.Boolean)
+	case expr.Precision == pkg.Complex:
+		err = expr.WriteComplexResultIntoTask(reqInJson.ID, reqInJson.Complex)
+	case expr.Precision.IsExact():
+		err = expr.WriteExactResultIntoTask(reqInJson.ID, reqInJson.GetExactValue(expr.Precision))
+	default:
+		err = expr.WriteResultIntoTask(reqInJson.ID, reqInJson.Result)
+	}
 	if err != nil {
-		if errors.Is(err, backend.TaskIDNotExist{}) {
-			w.WriteHeader(404)
+		var (
+			taskIDNotExist    backend.TaskIDNotExist
+			lateTaskResult    backend.LateTaskResult
+			invalidTaskResult backend.InvalidTaskResult
+		)
+		if errors.As(err, &taskIDNotExist) {
+			writeError(w, http.StatusNotFound, backend.ErrorJsonFabric(backend.TaskNotFoundCode))
+			return
+		} else if errors.As(err, &invalidTaskResult) {
+			log.Println(err)
+			writeError(w, http.StatusUnprocessableEntity, backend.ErrorJsonFabric(backend.InvalidPayloadCode))
+			return
+		} else if errors.As(err, &lateTaskResult) { // повторная доставка результата не считается ошибкой агента.
+			log.Println(err)
 			return
 		} else {
 			log.Panic(err)
 		}
 	}
+}
 
+// memoHandler возвращает статистику общего кэша результатов поддеревьев.
+func memoHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+	buf, err := backend.MemoStatsJsonTitle{Memo: exprsList.GetMemoCache().Stats()}.Marshal()
+	if err != nil {
+		log.Panic(err)
+	}
+	_, err = w.Write(buf)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func constantsHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodGet) {
+		return
+	}
+	var (
+		values        = constants.GetAll()
+		constantsJson = backend.ConstantsJsonTitle{Constants: make([]backend.ConstantJson, 0, len(values)),
+			Builtin: pkg.ConstantNames()}
+	)
+	for name, value := range values {
+		constantsJson.Constants = append(constantsJson.Constants, backend.ConstantJson{Name: name, Value: value})
+	}
+	slices.SortFunc(constantsJson.Constants, func(a, b backend.ConstantJson) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	buf, err := constantsJson.Marshal()
+	if err != nil {
+		log.Panic(err)
+	}
+	_, err = w.Write(buf)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func constantHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkMethod(w, r, http.MethodPut, http.MethodDelete) {
+		return
+	}
+	if !checkAdminToken(w, r) {
+		return
+	}
+	if r.Method == http.MethodPut {
+		constantPutHandler(w, r)
+	} else {
+		constantDeleteHandler(w, r)
+	}
+}
+
+// constantPutHandler регистрирует константу или меняет её значение: 201 для новой константы, 200 для
+// существующей. Выражения, принятые до изменения, считаются со старым значением.
+func constantPutHandler(w http.ResponseWriter, r *http.Request) {
+	if !checkJsonContentType(w, r) {
+		return
+	}
+	var valueJson backend.ConstantValueJson
+	if !decodeJsonBody(w, r, &valueJson) {
+		return
+	}
+	if valueJson.Value == nil {
+		writeError(w, http.StatusUnprocessableEntity, backend.ErrorJsonFabric(backend.InvalidPayloadCode))
+		return
+	}
+	var name = r.PathValue("name")
+	created, err := constants.Set(name, *valueJson.Value)
+	if err != nil {
+		writeConstantError(w, name, err)
+		return
+	}
+	buf, err := backend.ConstantJson{Name: name, Value: *valueJson.Value}.Marshal()
+	if err != nil {
+		log.Panic(err)
+	}
+	if created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	_, err = w.Write(buf)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+func constantDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	var name = r.PathValue("name")
+	if err := constants.Delete(name); err != nil {
+		writeConstantError(w, name, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeConstantError(w http.ResponseWriter, name string, err error) {
+	var (
+		httpCode int
+		code     backend.ErrorCode
+	)
+	switch {
+	case errors.Is(err, backend.InvalidConstantName):
+		httpCode, code = http.StatusUnprocessableEntity, backend.InvalidConstantCode
+	case errors.Is(err, backend.BuiltinConstant):
+		httpCode, code = http.StatusConflict, backend.BuiltinConstantCode
+	case errors.Is(err, backend.ConstantNotFound):
+		httpCode, code = http.StatusNotFound, backend.ConstantNotFoundCode
+	default:
+		log.Panic(err)
+	}
+	var errorJson = backend.ErrorJsonFabric(code)
+	errorJson.Error.Token = name
+	writeError(w, httpCode, errorJson)
+}
+
+// checkAdminToken проверяет заголовок Authorization: Bearer <adminToken>. Без настроенного токена отвечает 403:
+// открытый для всех API изменения констант опаснее выключенного.
+func checkAdminToken(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		writeError(w, http.StatusForbidden, backend.ErrorJsonFabric(backend.AdminDisabledCode))
+		return false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if found && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	writeError(w, http.StatusUnauthorized, backend.ErrorJsonFabric(backend.UnauthorizedCode))
+	return false
+}
+
+func notFoundHandler(w http.ResponseWriter, _ *http.Request) {
+	writeError(w, http.StatusNotFound, backend.ErrorJsonFabric(backend.EndpointNotFoundCode))
+}
+
+// checkMethod проверяет, что метод запроса входит в allowed. В противном случае отвечает 405 с заголовком Allow.
+func checkMethod(w http.ResponseWriter, r *http.Request, allowed ...string) bool {
+	if slices.Contains(allowed, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, backend.ErrorJsonFabric(backend.MethodNotAllowedCode))
+	return false
+}
+
+// checkJsonContentType проверяет, что тело запроса передано в JSON. Параметры вроде charset допускаются.
+func checkJsonContentType(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, backend.ErrorJsonFabric(backend.UnsupportedMediaTypeCode))
+		return false
+	}
+	return true
+}
+
+// decodeJsonBody читает тело запроса в v. Синтаксически неверный JSON даёт 400, корректный JSON с
+// неизвестными полями или полями не того типа — 422.
+func decodeJsonBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	var decoder = json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(v)
+	if err == nil {
+		return true
+	}
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &syntaxError) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		writeError(w, http.StatusBadRequest, backend.ErrorJsonFabric(backend.MalformedJsonCode))
+	} else {
+		writeError(w, http.StatusUnprocessableEntity, backend.ErrorJsonFabric(backend.InvalidPayloadCode))
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, httpCode int, errorJson backend.ErrorJson) {
+	buf, err := errorJson.Marshal()
+	if err != nil {
+		log.Panic(err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpCode)
+	_, err = w.Write(buf)
+	if err != nil {
+		log.Printf("не удалось отправить ошибку %s: %s", errorJson.Error.Code, err)
+	}
 }
 
 func panicMiddleware(next http.Handler) http.Handler {
@@ -194,7 +463,7 @@ func panicMiddleware(next http.Handler) http.Handler {
 }
 
 func writeInternalServerError(w http.ResponseWriter) {
-	w.WriteHeader(500)
+	writeError(w, http.StatusInternalServerError, backend.ErrorJsonFabric(backend.InternalErrorCode))
 	return
 }
 
@@ -203,7 +472,12 @@ func getHandler() (handler http.Handler) {
 	mux.HandleFunc("/api/v1/calculate", calcHandler)
 	mux.HandleFunc("/api/v1/expressions", expressionsHandler)
 	mux.HandleFunc("/api/v1/expressions/{ID}", expressionIdHandler)
+	mux.HandleFunc("/api/v1/expressions/{ID}/ast", expressionAstHandler)
+	mux.HandleFunc("/api/v1/memo", memoHandler)
+	mux.HandleFunc("/api/v1/constants", constantsHandler)
+	mux.HandleFunc("/api/v1/constants/{name}", constantHandler)
 	mux.HandleFunc("/internal/task", taskHandler)
+	mux.HandleFunc("/", notFoundHandler)
 	handler = panicMiddleware(mux)
 	return
 }
@@ -8,9 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/Debianov/calc-ya-go-24/pkg"
 	"github.com/stretchr/testify/assert"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"slices"
 	"strconv"
 	"testing"
@@ -19,6 +23,9 @@ import (
 
 var compareTemplate = "ожидается \"%s\", получен \"%s\""
 
+// expectedOperand — подсказка парсера для места, где ожидается операнд.
+const expectedOperand = "operand (number, identifier, function call, unary operator or '(')"
+
 // testThroughHandler запускает все тесты через handler, используя параметры testCases.
 // Генерируемый запрос всегда отправляется с заголовком "Content-Type": "application/json".
 func testThroughHandler[K, V backend.JsonPayload](handler func(w http.ResponseWriter, r *http.Request), t *testing.T,
@@ -83,6 +90,15 @@ func testThroughServeMux[K, V backend.JsonPayload](handler func(w http.ResponseW
 	}
 }
 
+// parseTree разбирает выражение без переменных для тестов, которым нужно выражение в exprsList.
+func parseTree(t *testing.T, expression string) pkg.Node {
+	tree, err := pkg.Parse(expression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
 type ExpressionStub struct {
 	ID int `json:"id"`
 }
@@ -97,7 +113,7 @@ func testCalcHandler201(t *testing.T) {
 		exprsList = backend.ExpressionListEmptyFabric()
 	})
 	var (
-		requestsToTest    = []backend.RequestJson{{"2+2*4"}, {"4*2+3*5"}}
+		requestsToTest    = []backend.RequestJson{{Expression: "2+2*4"}, {Expression: "4*2+3*5"}}
 		expectedResponses = []*ExpressionStub{{ID: 0}, {ID: 1}}
 		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
@@ -107,10 +123,10 @@ func testCalcHandler201(t *testing.T) {
 
 	var (
 		expectedLen   = len(expectedResponses)
-		expectedTasks = [][]backend.Task{{{PairID: 0, Arg1: int64(2), Arg2: int64(4), Operation: "*",
-			Status: backend.ReadyToCalc}, {PairID: 1, Arg2: int64(2), Operation: "+", Status: backend.WaitingOtherTasks}},
-			{{PairID: 2, Arg1: int64(4), Arg2: int64(2), Operation: "*", Status: backend.ReadyToCalc}, {PairID: 3,
-				Arg1: int64(3), Arg2: int64(5), Operation: "*", Status: backend.ReadyToCalc},
+		expectedTasks = [][]backend.Task{{{PairID: 0, Arg1: float64(2), Arg2: float64(4), Operation: "*",
+			Status: backend.ReadyToCalc}, {PairID: 1, Arg1: float64(2), Operation: "+", Status: backend.WaitingOtherTasks}},
+			{{PairID: 2, Arg1: float64(4), Arg2: float64(2), Operation: "*", Status: backend.ReadyToCalc}, {PairID: 3,
+				Arg1: float64(3), Arg2: float64(5), Operation: "*", Status: backend.ReadyToCalc},
 				{PairID: 5, Operation: "+", Status: backend.WaitingOtherTasks}},
 		}
 	)
@@ -136,33 +152,496 @@ func testCalcHandler201(t *testing.T) {
 	}
 }
 
+func testCalcHandler201Unary(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		requestsToTest    = []backend.RequestJson{{Expression: "-3+5"}, {Expression: "2*(-4)"}, {Expression: "--1"}}
+		expectedResponses = []*ExpressionStub{{ID: 0}, {ID: 1}, {ID: 2}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	var (
+		expectedTasks = [][]backend.Task{{{PairID: 0, Arg1: float64(3), Operation: "neg", Status: backend.ReadyToCalc},
+			{PairID: 1, Arg2: float64(5), Operation: "+", Status: backend.WaitingOtherTasks}},
+			{{PairID: 2, Arg1: float64(4), Operation: "neg", Status: backend.ReadyToCalc},
+				{PairID: 3, Arg1: float64(2), Operation: "*", Status: backend.WaitingOtherTasks}},
+			{{PairID: 6, Arg1: float64(1), Operation: "neg", Status: backend.ReadyToCalc},
+				{PairID: 7, Operation: "neg", Status: backend.WaitingOtherTasks}},
+		}
+	)
+	for exprInd := range expectedTasks {
+		expr, ok := exprsList.Get(exprInd)
+		if !ok {
+			t.Fatalf("выражение %d не найдено", exprInd)
+		}
+		assert.Equal(t, len(expectedTasks[exprInd]), expr.GetTasksHandler().Len())
+		for taskInd := range expectedTasks[exprInd] {
+			var (
+				task         = expr.GetTasksHandler().Get(taskInd)
+				expectedTask = &expectedTasks[exprInd][taskInd]
+			)
+			assert.Equal(t, expectedTask.PairID, task.PairID)
+			assert.Equal(t, expectedTask.Arg1, task.Arg1)
+			assert.Equal(t, expectedTask.Arg2, task.Arg2)
+			assert.Equal(t, expectedTask.Operation, task.Operation)
+			assert.Equal(t, expectedTask.Status, task.Status)
+		}
+	}
+}
+
+func testCalcHandler201Float(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		requestsToTest    = []backend.RequestJson{{Expression: "1.5*2-0.25"}}
+		expectedResponses = []*ExpressionStub{{ID: 0}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	expr, _ := exprsList.Get(0)
+	var (
+		multiplication = expr.GetTasksHandler().Get(0)
+		subtraction    = expr.GetTasksHandler().Get(1)
+	)
+	assert.Equal(t, 1.5, multiplication.Arg1)
+	assert.Equal(t, float64(2), multiplication.Arg2)
+	assert.Nil(t, subtraction.Arg1)
+	assert.Equal(t, 0.25, subtraction.Arg2)
+}
+
+// testCalcHandler201Power проверяет правую ассоциативность степени (2^3^2 = 2^(3^2)), синоним ** и то, что
+// степень связывает сильнее унарного минуса.
+func testCalcHandler201Power(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		requestsToTest    = []backend.RequestJson{{Expression: "2^3^2"}, {Expression: "-2**2"}}
+		expectedResponses = []*ExpressionStub{{ID: 0}, {ID: 1}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	var (
+		expectedTasks = [][]backend.Task{
+			{{PairID: 0, Arg1: float64(3), Arg2: float64(2), Operation: "^", Status: backend.ReadyToCalc},
+				{PairID: 1, Arg1: float64(2), Operation: "^", Status: backend.WaitingOtherTasks}},
+			{{PairID: 2, Arg1: float64(2), Arg2: float64(2), Operation: "^", Status: backend.ReadyToCalc},
+				{PairID: 3, Operation: "neg", Status: backend.WaitingOtherTasks}},
+		}
+	)
+	for exprInd := range expectedTasks {
+		expr, ok := exprsList.Get(exprInd)
+		if !ok {
+			t.Fatalf("выражение %d не найдено", exprInd)
+		}
+		assert.Equal(t, len(expectedTasks[exprInd]), expr.GetTasksHandler().Len())
+		for taskInd := range expectedTasks[exprInd] {
+			var (
+				task         = expr.GetTasksHandler().Get(taskInd)
+				expectedTask = &expectedTasks[exprInd][taskInd]
+			)
+			assert.Equal(t, expectedTask.PairID, task.PairID)
+			assert.Equal(t, expectedTask.Arg1, task.Arg1)
+			assert.Equal(t, expectedTask.Arg2, task.Arg2)
+			assert.Equal(t, expectedTask.Operation, task.Operation)
+			assert.Equal(t, expectedTask.Status, task.Status)
+		}
+	}
+}
+
+// testCalcHandler201Functions проверяет, что вызов функции становится задачей с аргументами в Args, а вложенные
+// выражения в аргументах — дочерними задачами.
+// testCalcHandler201Literals проверяет, что числа с экспонентой, разделителями и префиксами систем счисления
+// попадают в задачи уже посчитанными, а знак в экспоненте не считается оператором.
+func testCalcHandler201Literals(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		requestsToTest = []backend.RequestJson{{Expression: "1e-5+2.5E+3"}, {Expression: "1_000_000*0x1F"},
+			{Expression: "0b101-0o17"}, {Expression: "0xE+1"}, {Expression: ".5e1"}}
+		expectedResponses = []*ExpressionStub{{ID: 0}, {ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+		expectedArgs = [][2]float64{{1e-5, 2500}, {1e6, 31}, {5, 15}, {14, 1}}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	for exprInd, args := range expectedArgs {
+		expr, _ := exprsList.Get(exprInd)
+		var tasks = expr.GetTasksHandler()
+		if assert.Equal(t, 1, tasks.Len()) {
+			assert.Equal(t, args[0], tasks.Get(0).Arg1)
+			assert.Equal(t, args[1], tasks.Get(0).Arg2)
+		}
+	}
+	expr, _ := exprsList.Get(4)
+	assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+	assert.Equal(t, float64(5), expr.Result)
+}
+
+// testCalcHandler201Modulo проверяет, что % и // имеют приоритет умножения и левую ассоциативность, а // не
+// путается с двумя делениями.
+func testCalcHandler201Modulo(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		requestsToTest    = []backend.RequestJson{{Expression: "1+7%3*2"}, {Expression: "17//5//-2"}}
+		expectedResponses = []*ExpressionStub{{ID: 0}, {ID: 1}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	var expectedTasks = [][]backend.Task{
+		{{Arg1: float64(7), Arg2: float64(3), Operation: "%", Status: backend.ReadyToCalc},
+			{Arg2: float64(2), Operation: "*", Status: backend.WaitingOtherTasks},
+			{Arg1: float64(1), Operation: "+", Status: backend.WaitingOtherTasks}},
+		{{Arg1: float64(17), Arg2: float64(5), Operation: "//", Status: backend.ReadyToCalc},
+			{Arg1: float64(2), Operation: "neg", Status: backend.ReadyToCalc},
+			{Operation: "//", Status: backend.WaitingOtherTasks}},
+	}
+	for exprInd := range expectedTasks {
+		expr, _ := exprsList.Get(exprInd)
+		var tasks = expr.GetTasksHandler()
+		assert.Equal(t, len(expectedTasks[exprInd]), tasks.Len())
+		for taskInd := range expectedTasks[exprInd] {
+			var (
+				task         = tasks.Get(taskInd)
+				expectedTask = &expectedTasks[exprInd][taskInd]
+			)
+			assert.Equal(t, expectedTask.Arg1, task.Arg1)
+			assert.Equal(t, expectedTask.Arg2, task.Arg2)
+			assert.Equal(t, expectedTask.Operation, task.Operation)
+			assert.Equal(t, expectedTask.Status, task.Status)
+		}
+	}
+}
+
+func testCalcHandler201Functions(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		requestsToTest    = []backend.RequestJson{{Expression: "max(1, 2*3, 4)+sqrt(9)"}}
+		expectedResponses = []*ExpressionStub{{ID: 0}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	expr, _ := exprsList.Get(0)
+	var (
+		tasks          = expr.GetTasksHandler()
+		multiplication = tasks.Get(0)
+		maxCall        = tasks.Get(1)
+		sqrtCall       = tasks.Get(2)
+		addition       = tasks.Get(3)
+	)
+	assert.Equal(t, 4, tasks.Len())
+	assert.Equal(t, backend.ReadyToCalc, multiplication.Status)
+	assert.Equal(t, "max", maxCall.Operation)
+	assert.Equal(t, []interface{}{float64(1), nil, float64(4)}, maxCall.Args)
+	assert.Nil(t, maxCall.Arg1)
+	assert.Equal(t, backend.WaitingOtherTasks, maxCall.Status)
+	assert.Equal(t, "sqrt", sqrtCall.Operation)
+	assert.Equal(t, []interface{}{float64(9)}, sqrtCall.Args)
+	assert.Equal(t, backend.ReadyToCalc, sqrtCall.Status)
+	assert.Equal(t, backend.WaitingOtherTasks, addition.Status)
+
+	var postHttpCase = backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+		RequestsToSend: []*backend.AgentResult{{ID: 0, Result: 6}}, ExpectedResponses: []backend.EmptyJson{{}},
+		HttpMethod: "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK}
+	expr.FabricReadyExprSendTask()
+	testThroughHandler(taskHandler, t, postHttpCase)
+	assert.Equal(t, []interface{}{float64(1), float64(6), float64(4)}, maxCall.Args)
+	assert.Equal(t, backend.ReadyToCalc, maxCall.Status)
+}
+
+// testCalcHandler201Variables проверяет, что значения переменных подставляются в задачи и возвращаются вместе
+// с выражением.
+func testCalcHandler201Variables(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		variables         = map[string]float64{"a": 2, "b": -3, "c": 0.5, "x": 4, "unused": 1}
+		requestsToTest    = []backend.RequestJson{{Expression: "a*x^2+b*x+c", Variables: variables}}
+		expectedResponses = []*ExpressionStub{{ID: 0}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	expr, _ := exprsList.Get(0)
+	var tasks = expr.GetTasksHandler()
+	assert.Equal(t, variables, expr.Variables)
+	assert.Equal(t, 5, tasks.Len())
+	assert.Equal(t, float64(4), tasks.Get(0).Arg1)
+	assert.Equal(t, float64(2), tasks.Get(1).Arg1)
+	assert.Equal(t, float64(-3), tasks.Get(2).Arg1)
+	assert.Equal(t, 0.5, tasks.Get(4).Arg2)
+
+	var serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, *backend.ExpressionJsonTitle]{
+		RequestsToSend: []backend.EmptyJson{{}}, ExpectedResponses: []*backend.ExpressionJsonTitle{{Expression: expr}},
+		HttpMethod: "GET", UrlTemplate: "/api/v1/expressions/{ID}", UrlTarget: "/api/v1/expressions/0",
+		ExpectedHttpCode: http.StatusOK}
+	testThroughServeMux(expressionIdHandler, t, serverMuxHttpCase)
+}
+
+func testCalcHandler422Variables(t *testing.T) {
+	var invalidVariable = backend.ErrorJsonFabric(backend.InvalidVariableCode)
+	invalidVariable.Error.Token = "1x"
+	var (
+		requestsToTest = []backend.RequestJson{{Expression: "a+b", Variables: map[string]float64{"a": 1}},
+			{Expression: "x", Variables: map[string]float64{"1x": 1}}}
+		expectedResponses = []backend.ErrorJson{
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "b",
+				Expected: "value for 'b' in variables", Err: pkg.UnboundVariable}),
+			invalidVariable,
+		}
+		commonHttpCase = backend.HttpCases[backend.RequestJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusUnprocessableEntity}
+	)
+	assert.Equal(t, backend.UnboundVariableCode, expectedResponses[0].Error.Code)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+}
+
+func testCalcHandler201Constants(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+		constants = backend.ConstantsRegistryEmptyFabric()
+	})
+	if _, err := constants.Set("VAT_RATE", 0.2); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		requestsToTest = []backend.RequestJson{{Expression: "2*pi+100*VAT_RATE"}, {Expression: "1/inf"},
+			{Expression: "e*VAT_RATE", Variables: map[string]float64{"e": 3, "VAT_RATE": 0.1}}}
+		expectedResponses = []*ExpressionStub{{ID: 0}, {ID: 1}, {ID: 2}}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, *ExpressionStub]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	expr, _ := exprsList.Get(0)
+	var tasks = expr.GetTasksHandler()
+	assert.Equal(t, math.Pi, tasks.Get(0).Arg2)
+	assert.Equal(t, 0.2, tasks.Get(1).Arg2)
+
+	expr, _ = exprsList.Get(1)
+	assert.Equal(t, math.Inf(1), expr.GetTasksHandler().Get(0).Arg2)
+
+	expr, _ = exprsList.Get(2) // переменные запроса перекрывают и встроенные константы, и константы команды.
+	tasks = expr.GetTasksHandler()
+	assert.Equal(t, float64(3), tasks.Get(0).Arg1)
+	assert.Equal(t, 0.1, tasks.Get(0).Arg2)
+}
+
+func testCalcHandler201Simplify(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var cases = []struct {
+		request            backend.RequestJson
+		expectedTree       string
+		expectedTasksSaved int
+		expectedStatus     backend.ExprStatus
+	}{
+		{backend.RequestJson{Expression: "2*3", Simplify: true}, "6", 1, backend.Completed},
+		{backend.RequestJson{Expression: "x+0", Variables: map[string]float64{"x": 5}, Simplify: true}, "x", 1,
+			backend.Completed},
+		{backend.RequestJson{Expression: "(2+3)*(4+5)*(6+7)*sqrt(2)", Simplify: true}, "45 * 13 * sqrt(2)", 4,
+			backend.Ready},
+		{backend.RequestJson{Expression: "0*(sin(2)+3*4)", Simplify: true}, "0", 4, backend.Completed},
+		{backend.RequestJson{Expression: "sqrt(2)^1*1", Simplify: true}, "sqrt(2)", 2, backend.Ready},
+		// Отброшенное поддерево могло бы завершиться ошибкой, поэтому оно остаётся агентам.
+		{backend.RequestJson{Expression: "0*(1/0)", Simplify: true}, "0 * (1 / 0)", 0, backend.Ready},
+		{backend.RequestJson{Expression: "sqrt(-1)^0", Simplify: true}, "sqrt(-1) ^ 0", 1, backend.Ready},
+		{backend.RequestJson{Expression: "1e308*10+0", Simplify: true}, "1e+308 * 10", 1, backend.Ready},
+		{backend.RequestJson{Expression: "2*3"}, "2 * 3", 0, backend.Ready},
+	}
+	for ind, testCase := range cases {
+		t.Run(testCase.request.Expression, func(t *testing.T) {
+			testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+				RequestsToSend: []backend.RequestJson{testCase.request}, ExpectedResponses: []*ExpressionStub{{ID: ind}},
+				HttpMethod: "POST", UrlTarget: "/api/v1/calculate", ExpectedHttpCode: http.StatusCreated})
+			expr, _ := exprsList.Get(ind)
+			assert.Equal(t, testCase.expectedTree, pkg.Format(expr.GetTree()))
+			assert.Equal(t, testCase.expectedTasksSaved, expr.TasksSaved)
+			assert.Equal(t, testCase.expectedStatus, expr.Status)
+		})
+	}
+}
+
 func testCalcHandler422(t *testing.T) {
 	var (
-		requestsToTest = []backend.RequestJson{{"2++2*4"}, {"4*(2+3"}, {"8+2/3)"},
-			{"4*()2+3"}}
-		expectedResponses = []backend.EmptyJson{{}, {}, {}, {}}
-		commonHttpCase    = backend.HttpCases[backend.RequestJson, backend.EmptyJson]{RequestsToSend: requestsToTest,
+		requestsToTest = []backend.RequestJson{{Expression: "2+*2*4"}, {Expression: "4*(2+3"},
+			{Expression: "8+2/3)"}, {Expression: "4*()2+3"}, {Expression: "2*-"}, {Expression: "(-)+2"},
+			{Expression: "2 3"}, {Expression: "2+a"}, {Expression: ""}, {Expression: "2***3"},
+			{Expression: "foo(1)"}, {Expression: "atan2(1)"}, {Expression: "log(1,2,3)"}, {Expression: "7///2"},
+			{Expression: "%3"}, {Expression: "2*1e"}, {Expression: "0b102"}, {Expression: "1__0+1"},
+			{Expression: "1e400"}, {Expression: "neg 3"}, {Expression: "pos(4)"}}
+		expectedResponses = []backend.ErrorJson{
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "*", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 6, Expected: "')'",
+				Err: pkg.MismatchedParentheses}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 5, Token: ")",
+				Expected: "operator or end of expression", Err: pkg.MismatchedParentheses}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 3, Token: ")", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 3, Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: ")", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "3",
+				Expected: "operator or end of expression", Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "a",
+				Expected: "value for 'a' in variables", Err: pkg.UnboundVariable}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 3, Token: "*", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "foo", Expected: "function name",
+				Err: pkg.UnknownFunction}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 7, Token: ")", Expected: "atan2 to take 2 arguments",
+				Err: pkg.WrongArgumentsCount}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 7, Token: ",",
+				Expected: "log to take 1 to 2 arguments", Err: pkg.WrongArgumentsCount}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 3, Token: "/", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "%", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "1e",
+				Expected: "decimal, 0x, 0b or 0o number", Err: pkg.MalformedNumber}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "0b102",
+				Expected: "decimal, 0x, 0b or 0o number", Err: pkg.MalformedNumber}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "1__0",
+				Expected: "decimal, 0x, 0b or 0o number", Err: pkg.MalformedNumber}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "1e400",
+				Expected: "number within float64 range", Err: pkg.NumberOutOfRange}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "neg", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "pos", Expected: "function name",
+				Err: pkg.UnknownFunction}),
+		}
+		commonHttpCase = backend.HttpCases[backend.RequestJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
 			ExpectedHttpCode: http.StatusUnprocessableEntity}
 	)
 	testThroughHandler(calcHandler, t, commonHttpCase)
 }
 
+func testCalcHandler422Message(t *testing.T) {
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("POST", "/api/v1/calculate",
+			bytes.NewReader([]byte(`{"expression": "(1+2))*3"}`)))
+		response backend.ErrorJson
+	)
+	req.Header.Set("Content-Type", "application/json")
+	calcHandler(w, req)
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, backend.InvalidExpressionCode, response.Error.Code)
+	assert.Equal(t, "mismatched parentheses: unexpected ')' at column 6, expected operator or end of expression",
+		response.Error.Message)
+	assert.Equal(t, 5, *response.Error.Position)
+	assert.Equal(t, ")", response.Error.Token)
+}
+
 func testCalcHandlerGet(t *testing.T) {
 	var (
-		requestsToTest    = []backend.RequestJson{{"2+2*4"}}
-		expectedResponses = []*backend.EmptyJson{{}}
-		commonHttpCase    = backend.HttpCases[backend.RequestJson, *backend.EmptyJson]{RequestsToSend: requestsToTest,
+		requestsToTest    = []backend.RequestJson{{Expression: "2+2*4"}}
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.MethodNotAllowedCode)}
+		commonHttpCase    = backend.HttpCases[backend.RequestJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "GET", UrlTarget: "/api/v1/calculate",
-			ExpectedHttpCode: http.StatusOK}
+			ExpectedHttpCode: http.StatusMethodNotAllowed}
+	)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/api/v1/calculate", nil)
+	)
+	calcHandler(w, req)
+	assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+}
+
+// RawJson позволяет отправить в handler произвольные байты, в том числе некорректный JSON.
+type RawJson []byte
+
+func (r RawJson) Marshal() (result []byte, err error) {
+	return r, nil
+}
+
+func testCalcHandler400(t *testing.T) {
+	var (
+		requestsToTest    = []RawJson{RawJson(`{"expression": "2+2`), RawJson(``), RawJson(`{expression}`)}
+		malformedJson     = backend.ErrorJsonFabric(backend.MalformedJsonCode)
+		expectedResponses = []backend.ErrorJson{malformedJson, malformedJson, malformedJson}
+		commonHttpCase    = backend.HttpCases[RawJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusBadRequest}
 	)
 	testThroughHandler(calcHandler, t, commonHttpCase)
 }
 
+func testCalcHandler415(t *testing.T) {
+	var (
+		w        = httptest.NewRecorder()
+		req      = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewReader([]byte(`{"expression": "2+2"}`)))
+		expected []byte
+		err      error
+	)
+	req.Header.Set("Content-Type", "text/plain")
+	calcHandler(w, req)
+	expected, err = backend.ErrorJsonFabric(backend.UnsupportedMediaTypeCode).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Equal(t, expected, w.Body.Bytes())
+}
+
 func TestCalcHandler(t *testing.T) {
 	t.Run("TestCalcHandler201", testCalcHandler201)
+	t.Run("TestCalcHandler201Unary", testCalcHandler201Unary)
+	t.Run("TestCalcHandler201Float", testCalcHandler201Float)
+	t.Run("TestCalcHandler201Power", testCalcHandler201Power)
+	t.Run("TestCalcHandler201Literals", testCalcHandler201Literals)
+	t.Run("TestCalcHandler201Modulo", testCalcHandler201Modulo)
+	t.Run("TestCalcHandler201Functions", testCalcHandler201Functions)
+	t.Run("TestCalcHandler201Variables", testCalcHandler201Variables)
+	t.Run("TestCalcHandler422Variables", testCalcHandler422Variables)
+	t.Run("TestCalcHandler201Constants", testCalcHandler201Constants)
+	t.Run("TestCalcHandler201Simplify", testCalcHandler201Simplify)
 	t.Run("TestCalcHandler422", testCalcHandler422)
+	t.Run("TestCalcHandler422Message", testCalcHandler422Message)
 	t.Run("TestCalcHandlerGet", testCalcHandlerGet)
+	t.Run("TestCalcHandler400", testCalcHandler400)
+	t.Run("TestCalcHandler415", testCalcHandler415)
 }
 
 func testExpressionsHandler200(t *testing.T) {
@@ -177,7 +656,7 @@ func testExpressionsHandler200(t *testing.T) {
 	exprsList = backend.ExpressionListFabricWithElements(expectedExpressions)
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
-		expectedResponses = []*backend.ExpressionsJsonTitle{{expectedExpressions}}
+		expectedResponses = []*backend.ExpressionsJsonTitle{{Expressions: expectedExpressions}}
 		commonHttpCase    = backend.HttpCases[backend.EmptyJson, *backend.ExpressionsJsonTitle]{RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "GET", UrlTarget: "/api/v1/expressions",
 			ExpectedHttpCode: http.StatusOK}
 	)
@@ -194,10 +673,10 @@ func testExpressionsHandlerPost(t *testing.T) {
 	exprsList = backend.ExpressionListFabricWithElements(expectedExpressions)
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
-		expectedResponses = []*backend.EmptyJson{{}}
-		commonHttpCase    = backend.HttpCases[backend.EmptyJson, *backend.EmptyJson]{RequestsToSend: requestsToTest,
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.MethodNotAllowedCode)}
+		commonHttpCase    = backend.HttpCases[backend.EmptyJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/expressions",
-			ExpectedHttpCode: http.StatusOK}
+			ExpectedHttpCode: http.StatusMethodNotAllowed}
 	)
 	testThroughHandler(expressionsHandler, t, commonHttpCase)
 }
@@ -234,7 +713,7 @@ func testExpressionIdHandler200(t *testing.T) {
 		t.Run(fmt.Sprintf("ExpressionId%d", ind), func(t *testing.T) {
 			var (
 				requestsToTest    = []backend.EmptyJson{{}}
-				expectedResponses = []*backend.ExpressionJsonTitle{{expExpr}}
+				expectedResponses = []*backend.ExpressionJsonTitle{{Expression: expExpr}}
 				serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, *backend.ExpressionJsonTitle]{
 					RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "GET",
 					UrlTemplate: "/api/v1/expressions/{ID}", UrlTarget: fmt.Sprintf("/api/v1/expressions/%d", ind),
@@ -255,8 +734,8 @@ func testExpressionIdHandler404(t *testing.T) {
 	exprsList = backend.ExpressionListFabricWithElements(expectedExpressions)
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
-		expectedResponses = []*backend.EmptyJson{{}}
-		serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, *backend.EmptyJson]{
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.ExpressionNotFoundCode)}
+		serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, backend.ErrorJson]{
 			RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "GET",
 			UrlTemplate: "/api/v1/expressions/{ID}", UrlTarget: "/api/v1/expressions/1",
 			ExpectedHttpCode: http.StatusNotFound}
@@ -274,11 +753,11 @@ func testExpressionIdHandlerPost(t *testing.T) {
 	exprsList = backend.ExpressionListFabricWithElements(expectedExpressions)
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
-		expectedResponses = []*backend.EmptyJson{{}}
-		serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, *backend.EmptyJson]{
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.MethodNotAllowedCode)}
+		serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, backend.ErrorJson]{
 			RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "POST",
 			UrlTemplate: "/api/v1/expressions/{ID}", UrlTarget: "/api/v1/expressions/0",
-			ExpectedHttpCode: http.StatusOK}
+			ExpectedHttpCode: http.StatusMethodNotAllowed}
 	)
 	testThroughServeMux(expressionIdHandler, t, serverMuxHttpCase)
 }
@@ -287,8 +766,8 @@ func testExpressionIdHandlerEmpty(t *testing.T) {
 	exprsList = backend.ExpressionListEmptyFabric()
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
-		expectedResponses = []*backend.EmptyJson{{}}
-		serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, *backend.EmptyJson]{
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.ExpressionNotFoundCode)}
+		serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, backend.ErrorJson]{
 			RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "GET",
 			UrlTemplate: "/api/v1/expressions/{ID}", UrlTarget: "/api/v1/expressions/0",
 			ExpectedHttpCode: http.StatusNotFound}
@@ -296,18 +775,139 @@ func testExpressionIdHandlerEmpty(t *testing.T) {
 	testThroughServeMux(expressionIdHandler, t, serverMuxHttpCase)
 }
 
+func testExpressionIdHandler400(t *testing.T) {
+	exprsList = backend.ExpressionListEmptyFabric()
+	var (
+		requestsToTest    = []backend.EmptyJson{{}}
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.InvalidIdCode)}
+		serverMuxHttpCase = backend.ServerMuxHttpCases[backend.EmptyJson, backend.ErrorJson]{
+			RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "GET",
+			UrlTemplate: "/api/v1/expressions/{ID}", UrlTarget: "/api/v1/expressions/abc",
+			ExpectedHttpCode: http.StatusBadRequest}
+	)
+	testThroughServeMux(expressionIdHandler, t, serverMuxHttpCase)
+}
+
 func TestExpressionIdHandler(t *testing.T) {
 	t.Run("TestExpressionIdHandler200", testExpressionIdHandler200)
 	t.Run("TestExpressionIdHandler404", testExpressionIdHandler404)
 	t.Run("TestExpressionIdHandlerPost", testExpressionIdHandlerPost)
 	t.Run("TestExpressionIdHandlerEmpty", testExpressionIdHandlerEmpty)
+	t.Run("TestExpressionIdHandler400", testExpressionIdHandler400)
+}
+
+// getExpressionAst отправляет выражение в calcHandler и возвращает ответ GET /api/v1/expressions/{ID}/ast.
+func getExpressionAst(t *testing.T, request backend.RequestJson) (result backend.ExpressionAstJson) {
+	body, err := request.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("POST", "/api/v1/calculate", bytes.NewReader(body))
+	)
+	req.Header.Set("Content-Type", "application/json")
+	getHandler().ServeHTTP(w, req)
+	var created ExpressionStub
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil {
+		t.Fatalf("выражение %q не принято: %s", request.Expression, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	getHandler().ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/api/v1/expressions/%d/ast", created.ID), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var title backend.ExpressionAstJsonTitle
+	if err = json.Unmarshal(w.Body.Bytes(), &title); err != nil {
+		t.Fatal(err)
+	}
+	return title.Ast
+}
+
+func testExpressionAstHandler200(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var (
+		source = "2*(a+3)^2 - max(1, -x)"
+		ast    = getExpressionAst(t, backend.RequestJson{Expression: source,
+			Variables: map[string]float64{"a": 1, "x": 2}})
+	)
+	assert.Equal(t, source, ast.Source)
+	assert.Equal(t, "2 * (a + 3) ^ 2 - max(1, -x)", ast.Canonical)
+
+	root, ok := ast.Tree.Node.(*pkg.BinaryNode)
+	if !ok {
+		t.Fatalf("корень дерева — %T, ожидается *pkg.BinaryNode", ast.Tree.Node)
+	}
+	assert.Equal(t, "-", root.Operator)
+	assert.Equal(t, pkg.Span{Start: 0, End: len(source)}, root.Span())
+	assert.Equal(t, pkg.Span{Start: 12, End: len(source)}, root.Right.Span())
+	var power = root.Left.(*pkg.BinaryNode).Right.(*pkg.BinaryNode)
+	assert.Equal(t, pkg.Power, power.Operator)
+	assert.Equal(t, &pkg.NumberNode{Value: 1, Name: "a", Pos: pkg.Span{Start: 3, End: 4}},
+		power.Left.(*pkg.BinaryNode).Left)
+}
+
+// testExpressionAstHandlerCanonical проверяет, что канонический вид сохраняет только необходимые скобки и
+// разбирается обратно в то же выражение.
+func testExpressionAstHandlerCanonical(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var cases = []struct {
+		expression string
+		canonical  string
+	}{
+		{"((1+2))*3", "(1 + 2) * 3"}, {"1-(2-3)", "1 - (2 - 3)"}, {"(1-2)-3", "1 - 2 - 3"},
+		{"2^(3^2)", "2 ^ 3 ^ 2"}, {"(2^3)^2", "(2 ^ 3) ^ 2"}, {"(-2)^2", "(-2) ^ 2"}, {"-(2^2)", "-2 ^ 2"},
+		{"2**(-1)", "2 ^ -1"}, {"-(1+2)", "-(1 + 2)"}, {"1/(2*3)", "1 / (2 * 3)"}, {"7%(3//2)", "7 % (3 // 2)"},
+		{"0x1F+1e-5", "31 + 1e-05"}, {"max((1), (2+3)*4)", "max(1, (2 + 3) * 4)"}, {"- -1", "--1"},
+		{"1/inf", "1 / inf"}, {"(x)*(-y)", "x * -y"},
+	}
+	for _, testCase := range cases {
+		var (
+			variables = map[string]float64{"x": -3, "y": 2}
+			ast       = getExpressionAst(t, backend.RequestJson{Expression: testCase.expression, Variables: variables})
+			reparsed  = getExpressionAst(t, backend.RequestJson{Expression: ast.Canonical, Variables: variables})
+		)
+		assert.Equal(t, testCase.canonical, ast.Canonical)
+		assert.Equal(t, ast.Canonical, reparsed.Canonical)
+		assert.Equal(t, pkg.Postfix(ast.Tree.Node), pkg.Postfix(reparsed.Tree.Node))
+	}
+}
+
+func testExpressionAstHandlerErrors(t *testing.T) {
+	var cases = []struct {
+		target       string
+		expectedCode int
+		expectedJson backend.ErrorJson
+	}{
+		{"/api/v1/expressions/0/ast", http.StatusNotFound, backend.ErrorJsonFabric(backend.ExpressionNotFoundCode)},
+		{"/api/v1/expressions/abc/ast", http.StatusBadRequest, backend.ErrorJsonFabric(backend.InvalidIdCode)},
+	}
+	for _, testCase := range cases {
+		var w = httptest.NewRecorder()
+		getHandler().ServeHTTP(w, httptest.NewRequest("GET", testCase.target, nil))
+		expected, err := testCase.expectedJson.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, testCase.expectedCode, w.Code)
+		assert.Equal(t, expected, w.Body.Bytes())
+	}
+}
+
+func TestExpressionAstHandler(t *testing.T) {
+	t.Run("TestExpressionAstHandler200", testExpressionAstHandler200)
+	t.Run("TestExpressionAstHandlerCanonical", testExpressionAstHandlerCanonical)
+	t.Run("TestExpressionAstHandlerErrors", testExpressionAstHandlerErrors)
 }
 
 func testTaskGetHandler200(t *testing.T) {
 	t.Cleanup(func() {
 		exprsList = backend.ExpressionListEmptyFabric()
 	})
-	exprsList.ExprFabricAdd([]string{"2", "3", "*"})
+	exprsList.ExprFabricAdd(parseTree(t, "2*3"))
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
 		expectedResponses = []*backend.TaskToSend{{Task: &backend.Task{
@@ -328,8 +928,8 @@ func testTaskGetHandlerEmpty404(t *testing.T) {
 	exprsList = backend.ExpressionListEmptyFabric()
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
-		expectedResponses = []*backend.EmptyJson{{}}
-		commonHttpCase    = backend.HttpCases[backend.EmptyJson, *backend.EmptyJson]{RequestsToSend: requestsToTest,
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.NoReadyTasksCode)}
+		commonHttpCase    = backend.HttpCases[backend.EmptyJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "GET", UrlTarget: "/internal/task",
 			ExpectedHttpCode: http.StatusNotFound}
 	)
@@ -340,7 +940,7 @@ func testTaskGetHandler404(t *testing.T) {
 	t.Cleanup(func() {
 		exprsList = backend.ExpressionListEmptyFabric()
 	})
-	exprsList.ExprFabricAdd([]string{"2", "3", "*"})
+	exprsList.ExprFabricAdd(parseTree(t, "2*3"))
 	var (
 		requestsToTest    = []backend.EmptyJson{{}}
 		expectedResponses = []*backend.TaskToSend{{Task: &backend.Task{
@@ -351,11 +951,11 @@ func testTaskGetHandler404(t *testing.T) {
 			OperationTime: 1 * time.Second,
 		}}}
 		requestsToTest2    = []backend.EmptyJson{{}}
-		expectedResponses2 = []*backend.EmptyJson{{}}
+		expectedResponses2 = []backend.ErrorJson{backend.ErrorJsonFabric(backend.NoReadyTasksCode)}
 		commonHttpCase     = backend.HttpCases[backend.EmptyJson, *backend.TaskToSend]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "GET", UrlTarget: "/internal/task",
 			ExpectedHttpCode: http.StatusOK}
-		commonHttpCase2 = backend.HttpCases[backend.EmptyJson, *backend.EmptyJson]{RequestsToSend: requestsToTest2,
+		commonHttpCase2 = backend.HttpCases[backend.EmptyJson, backend.ErrorJson]{RequestsToSend: requestsToTest2,
 			ExpectedResponses: expectedResponses2, HttpMethod: "GET", UrlTarget: "/internal/task",
 			ExpectedHttpCode: http.StatusNotFound}
 	)
@@ -367,7 +967,7 @@ func testTaskPostHandler200(t *testing.T) {
 	t.Cleanup(func() {
 		exprsList = backend.ExpressionListEmptyFabric()
 	})
-	exprsList.ExprFabricAdd([]string{"2", "3", "*"})
+	exprsList.ExprFabricAdd(parseTree(t, "2*3"))
 	var (
 		requestsToTest    = []*backend.AgentResult{{ID: 0, Result: 6}}
 		expectedResponses = []backend.EmptyJson{{}}
@@ -383,16 +983,50 @@ func testTaskPostHandler200(t *testing.T) {
 	testThroughHandler(taskHandler, t, commonHttpCase)
 
 	if stubExpr.Result != 6 {
-		t.Errorf("Ожидается result %d по Expression %d, получен %d", 6, stubExpr.ID, stubExpr.Result)
+		t.Errorf("Ожидается result %d по Expression %d, получен %v", 6, stubExpr.ID, stubExpr.Result)
 	}
 }
 
+func testTaskPostHandlerCalcError(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	exprsList.ExprFabricAdd(parseTree(t, "1+5/0"))
+	var (
+		divisionByZero = backend.ErrorDetailsFabric(backend.DivisionByZeroCode)
+		commonHttpCase = backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+			RequestsToSend:    []*backend.AgentResult{{ID: 0, Error: &divisionByZero}},
+			ExpectedResponses: []backend.EmptyJson{{}}, HttpMethod: "POST", UrlTarget: "/internal/task",
+			ExpectedHttpCode: http.StatusOK}
+	)
+	stubExpr := exprsList.GetReadyExpr()
+	stubExpr.FabricReadyExprSendTask()
+
+	testThroughHandler(taskHandler, t, commonHttpCase)
+
+	var (
+		expectedExpr = &backend.Expression{ID: 0, Source: "1 + 5 / 0", Status: backend.Failed,
+			Precision: pkg.Float64, Error: &divisionByZero}
+		serverMuxCase = backend.ServerMuxHttpCases[backend.EmptyJson, *backend.ExpressionJsonTitle]{
+			RequestsToSend:    []backend.EmptyJson{{}},
+			ExpectedResponses: []*backend.ExpressionJsonTitle{{Expression: expectedExpr}}, HttpMethod: "GET",
+			UrlTemplate: "/api/v1/expressions/{ID}", UrlTarget: "/api/v1/expressions/0",
+			ExpectedHttpCode: http.StatusOK}
+		noTasksHttpCase = backend.HttpCases[backend.EmptyJson, backend.ErrorJson]{
+			RequestsToSend:    []backend.EmptyJson{{}},
+			ExpectedResponses: []backend.ErrorJson{backend.ErrorJsonFabric(backend.NoReadyTasksCode)}, HttpMethod: "GET",
+			UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusNotFound}
+	)
+	testThroughServeMux(expressionIdHandler, t, serverMuxCase)
+	testThroughHandler(taskHandler, t, noTasksHttpCase)
+}
+
 func testTaskPostHandler404(t *testing.T) {
 	exprsList = backend.ExpressionListEmptyFabric()
 	var (
 		requestsToTest    = []*backend.AgentResult{{ID: 0, Result: 6}}
-		expectedResponses = []backend.EmptyJson{{}}
-		commonHttpCase    = backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{RequestsToSend: requestsToTest,
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.ExpressionNotFoundCode)}
+		commonHttpCase    = backend.HttpCases[*backend.AgentResult, backend.ErrorJson]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/internal/task",
 			ExpectedHttpCode: http.StatusNotFound}
 	)
@@ -413,26 +1047,317 @@ func testTaskPostHandler422(t *testing.T) {
 	exprsList = backend.ExpressionListEmptyFabric()
 	var (
 		requestsToTest    = []*RandomJson{{Hey: 0, Issue: 6}}
-		expectedResponses = []backend.EmptyJson{{}}
-		commonHttpCase    = backend.HttpCases[*RandomJson, backend.EmptyJson]{RequestsToSend: requestsToTest,
+		expectedResponses = []backend.ErrorJson{backend.ErrorJsonFabric(backend.InvalidPayloadCode)}
+		commonHttpCase    = backend.HttpCases[*RandomJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
 			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/internal/task",
 			ExpectedHttpCode: http.StatusUnprocessableEntity}
 	)
 	testThroughHandler(taskHandler, t, commonHttpCase)
 }
 
-func TestTaskHandler(t *testing.T) {
-	t.Setenv("TIME_ADDITION_MS", "1s")
-	t.Setenv("TIME_SUBTRACTION_MS", "1s")
-	t.Setenv("TIME_MULTIPLICATIONS_MS", "1s")
-	t.Setenv("TIME_DIVISIONS_MS", "1s")
-
-	t.Run("TestTaskGetHandler200", testTaskGetHandler200)
-	t.Run("TestTaskGetHandlerEmpty404", testTaskGetHandlerEmpty404)
-	t.Run("TestTaskGetHandler404", testTaskGetHandler404)
-	t.Run("TestTaskPostHandler200", testTaskPostHandler200)
-	t.Run("TestTaskPostHandler404", testTaskPostHandler404)
-	//t.Run("TestTaskPostHandler422", testTaskPostHandler422) // TODO
+// testTaskHandlerParallel проверяет, что независимые поддеревья выражения раздаются агентам одновременно, а
+// результаты попадают в нужные аргументы родительской задачи.
+func testTaskHandlerParallel(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	exprsList.ExprFabricAdd(parseTree(t, "(1+2)-(3+4)"))
+	var (
+		requestsToTest    = []backend.EmptyJson{{}, {}}
+		expectedResponses = []*backend.TaskToSend{{Task: &backend.Task{PairID: 0, Arg1: 1, Arg2: 2, Operation: "+",
+			OperationTime: 1 * time.Second}}, {Task: &backend.Task{PairID: 1, Arg1: 3, Arg2: 4, Operation: "+",
+			OperationTime: 1 * time.Second}}}
+		commonHttpCase = backend.HttpCases[backend.EmptyJson, *backend.TaskToSend]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "GET", UrlTarget: "/internal/task",
+			ExpectedHttpCode: http.StatusOK}
+		noTasksHttpCase = backend.HttpCases[backend.EmptyJson, backend.ErrorJson]{
+			RequestsToSend:    []backend.EmptyJson{{}},
+			ExpectedResponses: []backend.ErrorJson{backend.ErrorJsonFabric(backend.NoReadyTasksCode)}, HttpMethod: "GET",
+			UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusNotFound}
+	)
+	testThroughHandler(taskHandler, t, commonHttpCase)
+	testThroughHandler(taskHandler, t, noTasksHttpCase)
+
+	var (
+		resultsHttpCase = backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+			RequestsToSend:    []*backend.AgentResult{{ID: 1, Result: 7}, {ID: 0, Result: 3}},
+			ExpectedResponses: []backend.EmptyJson{{}, {}}, HttpMethod: "POST", UrlTarget: "/internal/task",
+			ExpectedHttpCode: http.StatusOK}
+		rootHttpCase = backend.HttpCases[backend.EmptyJson, *backend.TaskToSend]{
+			RequestsToSend: []backend.EmptyJson{{}}, ExpectedResponses: []*backend.TaskToSend{{Task: &backend.Task{
+				PairID: 4, Arg1: 3, Arg2: 7, Operation: "-", OperationTime: 1 * time.Second}}}, HttpMethod: "GET",
+			UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK}
+		rootResultHttpCase = backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+			RequestsToSend: []*backend.AgentResult{{ID: 4, Result: -4}}, ExpectedResponses: []backend.EmptyJson{{}},
+			HttpMethod: "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK}
+	)
+	testThroughHandler(taskHandler, t, resultsHttpCase)
+	testThroughHandler(taskHandler, t, rootHttpCase)
+	testThroughHandler(taskHandler, t, rootResultHttpCase)
+
+	expr, _ := exprsList.Get(0)
+	assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+	assert.Equal(t, float64(-4), expr.Result)
+}
+
+// testTaskLease проверяет, что задача с истёкшей арендой возвращается в очередь и выдаётся повторно, опоздавший
+// результат игнорируется, а после исчерпания повторных отправок выражение получает статус Failed.
+func testTaskLease(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	exprsList.SetLeasePolicy(backend.LeasePolicy{Grace: 0, MaxRetries: 1})
+	expr, _ := exprsList.ExprFabricAdd(parseTree(t, "2*3"))
+	var (
+		getHttpCase = backend.HttpCases[backend.EmptyJson, *backend.TaskToSend]{
+			RequestsToSend: []backend.EmptyJson{{}}, ExpectedResponses: []*backend.TaskToSend{{Task: &backend.Task{
+				PairID: 0, Arg1: 2, Arg2: 3, Operation: "*", OperationTime: 1 * time.Second}}},
+			HttpMethod: "GET", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK}
+		lateResultHttpCase = backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+			RequestsToSend: []*backend.AgentResult{{ID: 0, Result: 6}}, ExpectedResponses: []backend.EmptyJson{{}},
+			HttpMethod: "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK}
+		expiredTime = time.Now().Add(time.Hour)
+	)
+	testThroughHandler(taskHandler, t, getHttpCase)
+	exprsList.ReapExpiredTasks(expiredTime)
+	assert.Equal(t, backend.ExprStatus(backend.Ready), expr.Status)
+	assert.Equal(t, backend.ReadyToCalc, expr.GetTasksHandler().Get(0).Status)
+
+	testThroughHandler(taskHandler, t, lateResultHttpCase)
+	assert.Equal(t, backend.ReadyToCalc, expr.GetTasksHandler().Get(0).Status)
+	assert.Equal(t, float64(0), expr.Result)
+
+	testThroughHandler(taskHandler, t, getHttpCase)
+	exprsList.ReapExpiredTasks(expiredTime)
+	assert.Equal(t, backend.ExprStatus(backend.Failed), expr.Status)
+	if assert.NotNil(t, expr.Error) {
+		assert.Equal(t, backend.TaskTimeoutCode, expr.Error.Code)
+	}
+	testThroughHandler(taskHandler, t, lateResultHttpCase)
+	assert.Equal(t, backend.ExprStatus(backend.Failed), expr.Status)
+}
+
+// testTaskLeaseOrder проверяет, что задачи с истёкшей арендой снова раздаются в том порядке, в каком были отправлены,
+// и раньше ещё не отправленных.
+func testTaskLeaseOrder(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	expr, _ := exprsList.ExprFabricAdd(parseTree(t, "(1+2)*(3+4)*(5+6)*(7+8)"))
+	var sent []int
+	for range 3 {
+		sent = append(sent, expr.FabricReadyExprSendTask().Task.PairID)
+	}
+	var notSent = pkg.Pair(0, 5) // 7+8 — шестой оператор в постфиксе.
+	exprsList.ReapExpiredTasks(time.Now().Add(time.Hour))
+	var resent []int
+	for range 4 {
+		resent = append(resent, expr.FabricReadyExprSendTask().Task.PairID)
+	}
+	assert.Equal(t, append(sent, notSent), resent)
+}
+
+func testTaskLeaseNotExpired(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	expr, _ := exprsList.ExprFabricAdd(parseTree(t, "2*3"))
+	expr.FabricReadyExprSendTask()
+	exprsList.ReapExpiredTasks(time.Now())
+	assert.Equal(t, backend.Sent, expr.GetTasksHandler().Get(0).Status)
+	assert.Equal(t, 0, expr.GetTasksHandler().ReadyLen())
+}
+
+func testTaskHandlerPut(t *testing.T) {
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("PUT", "/internal/task", nil)
+	)
+	taskHandler(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, POST", w.Header().Get("Allow"))
+}
+
+func TestTaskHandler(t *testing.T) {
+	t.Run("TestTaskGetHandler200", testTaskGetHandler200)
+	t.Run("TestTaskGetHandlerEmpty404", testTaskGetHandlerEmpty404)
+	t.Run("TestTaskGetHandler404", testTaskGetHandler404)
+	t.Run("TestTaskPostHandler200", testTaskPostHandler200)
+	t.Run("TestTaskPostHandler404", testTaskPostHandler404)
+	t.Run("TestTaskPostHandlerCalcError", testTaskPostHandlerCalcError)
+	t.Run("TestTaskHandlerParallel", testTaskHandlerParallel)
+	t.Run("TestTaskPostHandler422", testTaskPostHandler422)
+	t.Run("TestTaskLease", testTaskLease)
+	t.Run("TestTaskLeaseOrder", testTaskLeaseOrder)
+	t.Run("TestTaskLeaseNotExpired", testTaskLeaseNotExpired)
+	t.Run("TestTaskHandlerPut", testTaskHandlerPut)
+}
+
+// TestExpressionsStorage проверяет, что после перезапуска оркестратора выражения восстанавливаются из журнала:
+// посчитанные задачи не пересчитываются, а отправленные агенту, но не посчитанные, снова раздаются.
+func TestExpressionsStorage(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var storagePath = filepath.Join(t.TempDir(), "expressions.jsonl")
+	storage, err := backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprsList, err = backend.ExpressionListFabricWithStorage(storage, backend.DefaultLeasePolicy,
+		backend.OperationTimesDefaultFabric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		calcHttpCase = backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+			RequestsToSend:    []backend.RequestJson{{Expression: "(1+2)*(3+4)"}, {Expression: "2*3"}},
+			ExpectedResponses: []*ExpressionStub{{ID: 0}, {ID: 1}}, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusCreated}
+		postHttpCase = backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+			RequestsToSend: []*backend.AgentResult{{ID: 0, Result: 3}}, ExpectedResponses: []backend.EmptyJson{{}},
+			HttpMethod: "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK}
+	)
+	testThroughHandler(calcHandler, t, calcHttpCase)
+	firstExpr, _ := exprsList.Get(0)
+	firstExpr.FabricReadyExprSendTask()
+	firstExpr.FabricReadyExprSendTask()
+	testThroughHandler(taskHandler, t, postHttpCase)
+	if err = storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Close()
+	})
+	exprsList, err = backend.ExpressionListFabricWithStorage(storage, backend.DefaultLeasePolicy,
+		backend.OperationTimesDefaultFabric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredExpr, ok := exprsList.Get(0)
+	if !ok {
+		t.Fatal("выражение 0 не восстановлено")
+	}
+	var tasks = restoredExpr.GetTasksHandler()
+	assert.Equal(t, "(1+2)*(3+4)", restoredExpr.Source)
+	assert.Equal(t, pkg.Span{Start: 0, End: len(restoredExpr.Source)}, restoredExpr.GetTree().Span())
+	assert.Equal(t, backend.ExprStatus(backend.Ready), restoredExpr.Status)
+	assert.Equal(t, 2, tasks.Len()) // посчитанное 1+2 сразу стало числом.
+	assert.Equal(t, pkg.Pair(0, 1), tasks.Get(0).PairID)
+	assert.Equal(t, backend.ReadyToCalc, tasks.Get(0).Status)
+	assert.Equal(t, pkg.Pair(0, 2), tasks.Get(1).PairID)
+	assert.Equal(t, float64(3), tasks.Get(1).Arg1)
+	assert.Equal(t, 1, tasks.ReadyLen())
+
+	untouchedExpr, ok := exprsList.Get(1)
+	if !ok {
+		t.Fatal("выражение 1 не восстановлено")
+	}
+	assert.Equal(t, 1, untouchedExpr.GetTasksHandler().ReadyLen())
+
+	newExpr, _ := exprsList.ExprFabricAdd(parseTree(t, "1"))
+	assert.Equal(t, 2, newExpr.ID)
+}
+
+// TestExpressionsStorageCorrupted проверяет, что оркестратор не запускается с журналом, постфикс в котором нельзя
+// превратить обратно в дерево.
+func TestExpressionsStorageExact(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var storagePath = filepath.Join(t.TempDir(), "expressions.jsonl")
+	storage, err := backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprsList, err = backend.ExpressionListFabricWithStorage(storage, backend.DefaultLeasePolicy,
+		backend.OperationTimesDefaultFabric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, _ := exprsList.ExprFabricAddInput(backend.ExpressionInput{Tree: parseTree(t, "1/3+1"),
+		Precision: pkg.Big, Bits: 64})
+	var sent = expr.FabricReadyExprSendTask()
+	assert.Nil(t, expr.WriteExactResultIntoTask(sent.Task.PairID, "1/3"))
+	if err = storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Close()
+	})
+	exprsList, err = backend.ExpressionListFabricWithStorage(storage, backend.DefaultLeasePolicy,
+		backend.OperationTimesDefaultFabric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredExpr, ok := exprsList.Get(0)
+	if !ok {
+		t.Fatal("выражение 0 не восстановлено")
+	}
+	assert.Equal(t, pkg.Big, restoredExpr.Precision)
+	assert.Equal(t, uint(64), restoredExpr.Bits)
+	var tasks = restoredExpr.GetTasksHandler()
+	assert.Equal(t, 1, tasks.Len())
+	assert.Equal(t, "1/3", tasks.Get(0).Arg1)
+	assert.Equal(t, "1", tasks.Get(0).Arg2)
+	assert.Equal(t, pkg.Big, tasks.Get(0).Precision)
+}
+
+func TestExpressionsStorageCorrupted(t *testing.T) {
+	var storagePath = filepath.Join(t.TempDir(), "expressions.jsonl")
+	var journal = `{"id": 0, "postfix": ["1", "2", "+", "*"], "status": "Есть готовые задачи", "result": 0}` + "\n"
+	if err := os.WriteFile(storagePath, []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	storage, err := backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Close()
+	})
+	list, err := backend.ExpressionListFabricWithStorage(storage, backend.DefaultLeasePolicy,
+		backend.OperationTimesDefaultFabric())
+	assert.ErrorIs(t, err, pkg.InvalidExpression)
+	assert.Nil(t, list)
+}
+
+// TestExpressionsStorageCompaction проверяет, что журнал сжимается и во время работы, а не только при запуске.
+func TestExpressionsStorageCompaction(t *testing.T) {
+	var storagePath = filepath.Join(t.TempDir(), "expressions.jsonl")
+	storage, err := backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Close()
+	})
+	const saves = 300
+	for ind := 0; ind < saves; ind++ {
+		if err = storage.Save(backend.ExpressionRecord{ID: ind % 2, Result: float64(ind)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	journal, err := os.ReadFile(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Less(t, bytes.Count(journal, []byte("\n")), saves)
+	records, err := storage.LoadAll()
+	if assert.NoError(t, err) && assert.Len(t, records, 2) {
+		assert.Equal(t, float64(saves-2), records[0].Result)
+		assert.Equal(t, float64(saves-1), records[1].Result)
+	}
 }
 
 func TestPanicMiddlewareGood(t *testing.T) {
@@ -474,6 +1399,713 @@ func mockHandlerWithPanic(_ http.ResponseWriter, _ *http.Request) {
 	panic(errors.New("ААААААА!!!!"))
 }
 
+// sendConstantRequest отправляет запрос к API констант через getHandler, чтобы проверить и маршрутизацию.
+func sendConstantRequest(t *testing.T, method, target, token string, body []byte) *httptest.ResponseRecorder {
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest(method, target, bytes.NewReader(body))
+	)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	getHandler().ServeHTTP(w, req)
+	return w
+}
+
+func marshalConstantValue(t *testing.T, value float64) []byte {
+	buf, err := backend.ConstantValueJson{Value: &value}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func testConstantsHandlerLifecycle(t *testing.T) {
+	var path = filepath.Join(t.TempDir(), "constants.json")
+	registry, err := backend.ConstantsRegistryFabricWithFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constants, adminToken = registry, "secret"
+	t.Cleanup(func() {
+		constants, adminToken = backend.ConstantsRegistryEmptyFabric(), ""
+	})
+
+	var w = sendConstantRequest(t, "PUT", "/api/v1/constants/VAT_RATE", "secret", marshalConstantValue(t, 0.2))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"name": "VAT_RATE", "value": 0.2}`, w.Body.String())
+	w = sendConstantRequest(t, "PUT", "/api/v1/constants/VAT_RATE", "secret", marshalConstantValue(t, 0.18))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = sendConstantRequest(t, "PUT", "/api/v1/constants/g", "secret", marshalConstantValue(t, 9.81))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = sendConstantRequest(t, "GET", "/api/v1/constants", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	expected, err := backend.ConstantsJsonTitle{Constants: []backend.ConstantJson{{Name: "VAT_RATE", Value: 0.18},
+		{Name: "g", Value: 9.81}}, Builtin: pkg.ConstantNames()}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expected, w.Body.Bytes())
+
+	w = sendConstantRequest(t, "DELETE", "/api/v1/constants/g", "secret", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+
+	reloaded, err := backend.ConstantsRegistryFabricWithFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, map[string]float64{"VAT_RATE": 0.18}, reloaded.GetAll())
+}
+
+func testConstantsHandlerErrors(t *testing.T) {
+	adminToken = "secret"
+	t.Cleanup(func() {
+		constants, adminToken = backend.ConstantsRegistryEmptyFabric(), ""
+	})
+	var cases = []struct {
+		name             string
+		method           string
+		target           string
+		body             []byte
+		expectedHttpCode int
+		expectedCode     backend.ErrorCode
+		expectedToken    string
+	}{
+		{name: "builtin put", method: "PUT", target: "/api/v1/constants/pi", body: marshalConstantValue(t, 3),
+			expectedHttpCode: http.StatusConflict, expectedCode: backend.BuiltinConstantCode, expectedToken: "pi"},
+		{name: "builtin delete", method: "DELETE", target: "/api/v1/constants/inf",
+			expectedHttpCode: http.StatusConflict, expectedCode: backend.BuiltinConstantCode, expectedToken: "inf"},
+		{name: "invalid name", method: "PUT", target: "/api/v1/constants/1x", body: marshalConstantValue(t, 1),
+			expectedHttpCode: http.StatusUnprocessableEntity, expectedCode: backend.InvalidConstantCode,
+			expectedToken: "1x"},
+		{name: "function name", method: "PUT", target: "/api/v1/constants/sqrt", body: marshalConstantValue(t, 1),
+			expectedHttpCode: http.StatusUnprocessableEntity, expectedCode: backend.InvalidConstantCode,
+			expectedToken: "sqrt"},
+		{name: "conditional name", method: "PUT", target: "/api/v1/constants/if", body: marshalConstantValue(t, 1),
+			expectedHttpCode: http.StatusUnprocessableEntity, expectedCode: backend.InvalidConstantCode,
+			expectedToken: "if"},
+		{name: "unknown constant", method: "DELETE", target: "/api/v1/constants/missing",
+			expectedHttpCode: http.StatusNotFound, expectedCode: backend.ConstantNotFoundCode,
+			expectedToken: "missing"},
+		{name: "missing value", method: "PUT", target: "/api/v1/constants/x", body: []byte(`{}`),
+			expectedHttpCode: http.StatusUnprocessableEntity, expectedCode: backend.InvalidPayloadCode},
+		{name: "post", method: "POST", target: "/api/v1/constants/x", body: marshalConstantValue(t, 1),
+			expectedHttpCode: http.StatusMethodNotAllowed, expectedCode: backend.MethodNotAllowedCode},
+	}
+	for _, testCase := range cases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var w = sendConstantRequest(t, testCase.method, testCase.target, "secret", testCase.body)
+			assert.Equal(t, testCase.expectedHttpCode, w.Code)
+			var expectedJson = backend.ErrorJsonFabric(testCase.expectedCode)
+			expectedJson.Error.Token = testCase.expectedToken
+			expected, err := expectedJson.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, expected, w.Body.Bytes())
+		})
+	}
+	assert.Empty(t, constants.GetAll())
+}
+
+func testConstantsHandlerAdminToken(t *testing.T) {
+	adminToken = "secret"
+	t.Cleanup(func() {
+		adminToken = ""
+		constants = backend.ConstantsRegistryEmptyFabric()
+	})
+	for _, token := range []string{"", "wrong"} {
+		var w = sendConstantRequest(t, "PUT", "/api/v1/constants/VAT_RATE", token, marshalConstantValue(t, 0.2))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+	}
+	assert.Empty(t, constants.GetAll())
+
+	var w = sendConstantRequest(t, "PUT", "/api/v1/constants/VAT_RATE", "secret", marshalConstantValue(t, 0.2))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = sendConstantRequest(t, "GET", "/api/v1/constants", "", nil) // список констант доступен без токена.
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = sendConstantRequest(t, "DELETE", "/api/v1/constants/VAT_RATE", "secret", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+// testConstantsHandlerAdminDisabled проверяет, что без настроенного токена константы изменить нельзя никому.
+func testConstantsHandlerAdminDisabled(t *testing.T) {
+	t.Cleanup(func() {
+		constants = backend.ConstantsRegistryEmptyFabric()
+	})
+	var expected, err = backend.ErrorJsonFabric(backend.AdminDisabledCode).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"", "anything"} {
+		var w = sendConstantRequest(t, "PUT", "/api/v1/constants/VAT_RATE", token, marshalConstantValue(t, 0.2))
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, expected, w.Body.Bytes())
+		w = sendConstantRequest(t, "DELETE", "/api/v1/constants/VAT_RATE", token, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	}
+	assert.Empty(t, constants.GetAll())
+	var w = sendConstantRequest(t, "GET", "/api/v1/constants", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestConstantsHandler(t *testing.T) {
+	t.Run("TestConstantsHandlerLifecycle", testConstantsHandlerLifecycle)
+	t.Run("TestConstantsHandlerErrors", testConstantsHandlerErrors)
+	t.Run("TestConstantsHandlerAdminToken", testConstantsHandlerAdminToken)
+	t.Run("TestConstantsHandlerAdminDisabled", testConstantsHandlerAdminDisabled)
+}
+
+func testMemoCommonSubexpressions(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	expr, _ := exprsList.ExprFabricAdd(parseTree(t, "(1+2)*(1+2)"))
+	var tasks = expr.GetTasksHandler()
+	assert.Equal(t, 2, tasks.Len())
+	assert.Equal(t, 1, expr.TasksReused)
+
+	var sent = expr.FabricReadyExprSendTask()
+	assert.Equal(t, "+", sent.Task.Operation)
+	assert.Nil(t, expr.WriteResultIntoTask(sent.Task.PairID, 3))
+	assert.Equal(t, float64(3), tasks.Get(1).Arg1)
+	assert.Equal(t, float64(3), tasks.Get(1).Arg2)
+	assert.Equal(t, backend.ReadyToCalc, tasks.Get(1).Status)
+}
+
+func testMemoCache(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	exprsList.SetMemoCache(backend.MemoCacheFabric(10))
+	first, _ := exprsList.ExprFabricAdd(parseTree(t, "2*3"))
+	var sent = first.FabricReadyExprSendTask()
+	assert.Nil(t, first.WriteResultIntoTask(sent.Task.PairID, 6))
+
+	second, _ := exprsList.ExprFabricAdd(parseTree(t, "2*3+1"))
+	assert.Equal(t, 1, second.GetTasksHandler().Len())
+	assert.Equal(t, float64(6), second.GetTasksHandler().Get(0).Arg1)
+	assert.Equal(t, pkg.Pair(second.ID, 1), second.GetTasksHandler().Get(0).PairID)
+	assert.Equal(t, 1, second.TasksReused)
+
+	third, _ := exprsList.ExprFabricAdd(parseTree(t, "2 * 3"))
+	assert.Equal(t, backend.ExprStatus(backend.Completed), third.Status)
+	assert.Equal(t, float64(6), third.Result)
+
+	var w = sendConstantRequest(t, "GET", "/api/v1/memo", "", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"memo": {"capacity": 10, "size": 1, "hits": 2, "misses": 2}}`, w.Body.String())
+}
+
+func testMemoCacheEviction(t *testing.T) {
+	var (
+		memo  = backend.MemoCacheFabric(2)
+		tree  = parseTree(t, "(1+2)*(3+4)")
+		keys  = pkg.HashTree(tree)
+		other = parseTree(t, "1 + 2")
+	)
+	assert.Equal(t, keys[tree.(*pkg.BinaryNode).Left], pkg.HashTree(other)[other])
+	memo.Put(keys[tree.(*pkg.BinaryNode).Left], float64(3))
+	memo.Put(keys[tree.(*pkg.BinaryNode).Right], float64(7))
+	memo.Put(keys[tree], float64(21))
+	_, ok := memo.Get(keys[tree.(*pkg.BinaryNode).Left])
+	assert.False(t, ok)
+	value, ok := memo.Get(keys[tree])
+	assert.True(t, ok)
+	assert.Equal(t, float64(21), value)
+	assert.Equal(t, backend.MemoStatsJson{Capacity: 2, Size: 2, Hits: 1, Misses: 1}, memo.Stats())
+
+	var disabled = backend.MemoCacheFabric(0)
+	disabled.Put(keys[tree], float64(21))
+	_, ok = disabled.Get(keys[tree])
+	assert.False(t, ok)
+	assert.Equal(t, backend.MemoStatsJson{}, disabled.Stats())
+}
+
+func testPrecisionBig(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+		RequestsToSend:    []backend.RequestJson{{Expression: "0.1+0.2", Precision: "big"}},
+		ExpectedResponses: []*ExpressionStub{{ID: 0}}, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+		ExpectedHttpCode: http.StatusCreated})
+	expr, _ := exprsList.Get(0)
+	assert.Equal(t, pkg.Big, expr.Precision)
+	assert.Equal(t, pkg.DefaultBits, expr.Bits)
+
+	var sent = expr.FabricReadyExprSendTask()
+	assert.Equal(t, "1/10", sent.Task.Arg1)
+	assert.Equal(t, "1/5", sent.Task.Arg2)
+	assert.Equal(t, pkg.Big, sent.Task.Precision)
+
+	testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+		RequestsToSend:    []*backend.AgentResult{{ID: sent.Task.PairID, Result: 0.3, Value: "3/10"}},
+		ExpectedResponses: []backend.EmptyJson{{}}, HttpMethod: "POST", UrlTarget: "/internal/task",
+		ExpectedHttpCode: http.StatusOK})
+	assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+	assert.Equal(t, "0.3", expr.Value)
+	assert.Equal(t, 0.3, expr.Result)
+}
+
+func testPrecisionInt64(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+		RequestsToSend:    []backend.RequestJson{{Expression: "9007199254740993*2", Precision: "int64"}},
+		ExpectedResponses: []*ExpressionStub{{ID: 0}}, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+		ExpectedHttpCode: http.StatusCreated})
+	expr, _ := exprsList.Get(0)
+	var sent = expr.FabricReadyExprSendTask()
+	assert.Equal(t, "9007199254740993", sent.Task.Arg1) // float64 округлил бы до 9007199254740992.
+
+	var invalidPayload = backend.HttpCases[*backend.AgentResult, backend.ErrorJson]{
+		RequestsToSend: []*backend.AgentResult{{ID: sent.Task.PairID, Result: 18014398509481986},
+			{ID: sent.Task.PairID, Value: "1/2"}},
+		ExpectedResponses: []backend.ErrorJson{backend.ErrorJsonFabric(backend.InvalidPayloadCode),
+			backend.ErrorJsonFabric(backend.InvalidPayloadCode)},
+		HttpMethod: "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusUnprocessableEntity}
+	testThroughHandler(taskHandler, t, invalidPayload)
+
+	testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+		RequestsToSend:    []*backend.AgentResult{{ID: sent.Task.PairID, Value: "18014398509481986"}},
+		ExpectedResponses: []backend.EmptyJson{{}}, HttpMethod: "POST", UrlTarget: "/internal/task",
+		ExpectedHttpCode: http.StatusOK})
+	assert.Equal(t, "18014398509481986", expr.Value)
+}
+
+func testPrecisionRational(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+		RequestsToSend:    []backend.RequestJson{{Expression: "1/3+1/6", Precision: "rational"}},
+		ExpectedResponses: []*ExpressionStub{{ID: 0}}, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+		ExpectedHttpCode: http.StatusCreated})
+	expr, _ := exprsList.Get(0)
+
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/internal/task", nil)
+	)
+	taskHandler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"task": {"id": 0, "arg1": {"num": "1", "den": "1"}, "arg2": {"num": "3", "den": "1"},
+		"operation": "/", "operationTime": 1000000000, "precision": "rational"}}`, w.Body.String())
+
+	var postFraction = func(id int, fraction backend.Fraction) {
+		testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+			RequestsToSend: []*backend.AgentResult{{ID: id, Fraction: &fraction}}, ExpectedResponses: []backend.EmptyJson{{}},
+			HttpMethod: "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK})
+	}
+	postFraction(0, backend.Fraction{Num: "2", Den: "6"})
+	var sum = expr.GetTasksHandler().Get(2)
+	assert.Equal(t, "1/3", sum.Arg1) // дробь сокращена.
+
+	var second = expr.FabricReadyExprSendTask()
+	testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.ErrorJson]{
+		RequestsToSend:    []*backend.AgentResult{{ID: second.Task.PairID, Value: "1/6"}},
+		ExpectedResponses: []backend.ErrorJson{backend.ErrorJsonFabric(backend.InvalidPayloadCode)},
+		HttpMethod:        "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusUnprocessableEntity})
+	postFraction(second.Task.PairID, backend.Fraction{Num: "1", Den: "6"})
+	expr.FabricReadyExprSendTask()
+	postFraction(sum.PairID, backend.Fraction{Num: "1", Den: "2"})
+	assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+	assert.Equal(t, "1/2", expr.Fraction)
+	assert.Equal(t, "0.5", expr.Value)
+	assert.Equal(t, 0.5, expr.Result)
+}
+
+func testPrecisionComplex(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+		RequestsToSend: []backend.RequestJson{{Expression: "3+4i"}, {Expression: "i*i", Variables: map[string]float64{"i": 3}},
+			{Expression: "abs(2*i)"}},
+		ExpectedResponses: []*ExpressionStub{{ID: 0}, {ID: 1}, {ID: 2}}, HttpMethod: "POST",
+		UrlTarget: "/api/v1/calculate", ExpectedHttpCode: http.StatusCreated})
+	expr, _ := exprsList.Get(0)
+	assert.Equal(t, pkg.Complex, expr.Precision)
+	assert.Equal(t, "3 + 4i", pkg.Format(expr.GetTree()))
+	var decoded pkg.NodeJson
+	if buf, err := json.Marshal(pkg.NodeJson{Node: expr.GetTree()}); assert.NoError(t, err) {
+		assert.NoError(t, json.Unmarshal(buf, &decoded))
+		assert.Equal(t, expr.GetTree(), decoded.Node)
+	}
+	other, _ := exprsList.Get(1) // переменная i перекрывает мнимую единицу.
+	assert.Equal(t, pkg.Float64, other.Precision)
+	other, _ = exprsList.Get(2)
+	assert.Equal(t, pkg.Complex, other.Precision)
+	assert.Equal(t, 1i, other.GetTasksHandler().Get(0).Arg2)
+
+	// GetReadyExpr выбирает среди готовых выражений произвольное, поэтому задачу получаем при единственном выражении.
+	exprsList = backend.ExpressionListEmptyFabric()
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+		RequestsToSend: []backend.RequestJson{{Expression: "3+4i"}}, ExpectedResponses: []*ExpressionStub{{ID: 0}},
+		HttpMethod: "POST", UrlTarget: "/api/v1/calculate", ExpectedHttpCode: http.StatusCreated})
+	expr, _ = exprsList.Get(0)
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/internal/task", nil)
+	)
+	taskHandler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"task": {"id": 0, "arg1": {"re": 3, "im": 0}, "arg2": {"re": 0, "im": 4}, "operation": "+",
+		"operationTime": 1000000000, "precision": "complex"}}`, w.Body.String())
+
+	testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.ErrorJson]{
+		RequestsToSend:    []*backend.AgentResult{{ID: 0, Result: 3}},
+		ExpectedResponses: []backend.ErrorJson{backend.ErrorJsonFabric(backend.InvalidPayloadCode)},
+		HttpMethod:        "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusUnprocessableEntity})
+	testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+		RequestsToSend:    []*backend.AgentResult{{ID: 0, Result: 3, Complex: &backend.Complex{Re: 3, Im: 4}}},
+		ExpectedResponses: []backend.EmptyJson{{}}, HttpMethod: "POST", UrlTarget: "/internal/task",
+		ExpectedHttpCode: http.StatusOK})
+	marshaled, err := expr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `{"id": 0, "source": "3+4i", "status": "Выполнено", "result": {"re": 3, "im": 4},
+		"precision": "complex"}`, string(marshaled))
+}
+
+func testPrecision422(t *testing.T) {
+	var invalidPrecision = func(token string) backend.ErrorJson {
+		var result = backend.ErrorJsonFabric(backend.InvalidPrecisionCode)
+		result.Error.Token = token
+		return result
+	}
+	var (
+		requestsToTest = []backend.RequestJson{{Expression: "1+1.5", Precision: "int64"},
+			{Expression: "sqrt(4)", Precision: "int64"}, {Expression: "sin(1)", Precision: "big"}, {Expression: "sqrt(4)", Precision: "rational"}, {Expression: "4i", Precision: "float64"},
+			{Expression: "i%2"}, {Expression: "re(2)", Precision: "int64"}, {Expression: "inf*i"},
+			{Expression: "1", Precision: "decimal"}, {Expression: "1", Precision: "int64", Bits: 128},
+			{Expression: "1", Precision: "big", Bits: 8}, {Expression: "1", Bits: 128},
+			{Expression: "2^0.5", Precision: "big"}, {Expression: "2^(1/2)", Precision: "rational"}}
+		expectedResponses = []backend.ErrorJson{
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "1.5",
+				Expected: "integer within int64 range", Err: pkg.NotAnInteger}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "sqrt",
+				Expected: "operation supported in int64 precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "sin",
+				Expected: "operation supported in big precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "sqrt",
+				Expected: "operation supported in rational precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "4i",
+				Expected: "real number in float64 precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "%",
+				Expected: "operation supported in complex precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "re",
+				Expected: "operation supported in int64 precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "inf", Expected: "finite number",
+				Err: pkg.NumberOutOfRange}),
+			invalidPrecision("decimal"), invalidPrecision("int64"), invalidPrecision("big"), invalidPrecision(""),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "0.5",
+				Expected: "integer exponent in big precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "1 / 2",
+				Expected: "integer exponent in rational precision", Err: pkg.UnsupportedOperation}),
+		}
+		commonHttpCase = backend.HttpCases[backend.RequestJson, backend.ErrorJson]{RequestsToSend: requestsToTest,
+			ExpectedResponses: expectedResponses, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+			ExpectedHttpCode: http.StatusUnprocessableEntity}
+	)
+	assert.Equal(t, backend.NotAnIntegerCode, expectedResponses[0].Error.Code)
+	assert.Equal(t, backend.UnsupportedOperationCode, expectedResponses[1].Error.Code)
+	testThroughHandler(calcHandler, t, commonHttpCase)
+}
+
+func TestPrecision(t *testing.T) {
+	t.Run("TestPrecisionBig", testPrecisionBig)
+	t.Run("TestPrecisionInt64", testPrecisionInt64)
+	t.Run("TestPrecisionRational", testPrecisionRational)
+	t.Run("TestPrecisionComplex", testPrecisionComplex)
+	t.Run("TestPrecision422", testPrecision422)
+}
+
+func TestMemo(t *testing.T) {
+	t.Run("TestMemoCommonSubexpressions", testMemoCommonSubexpressions)
+	t.Run("TestMemoCache", testMemoCache)
+	t.Run("TestMemoCacheEviction", testMemoCacheEviction)
+}
+
+func testLogical201(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+		RequestsToSend:    []backend.RequestJson{{Expression: "2 < 3 && !(1 == 2)"}},
+		ExpectedResponses: []*ExpressionStub{{ID: 0}}, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+		ExpectedHttpCode: http.StatusCreated})
+	expr, _ := exprsList.Get(0)
+	var tasks = expr.GetTasksHandler()
+	assert.Equal(t, 4, tasks.Len())
+
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/internal/task", nil)
+	)
+	taskHandler(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"task": {"id": 0, "arg1": 2, "arg2": 3, "operation": "<", "operationTime": 1000000000}}`,
+		w.Body.String())
+
+	var postBoolean = func(id int, value bool) {
+		testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.EmptyJson]{
+			RequestsToSend: []*backend.AgentResult{{ID: id, Boolean: &value}}, ExpectedResponses: []backend.EmptyJson{{}},
+			HttpMethod: "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusOK})
+	}
+	testThroughHandler(taskHandler, t, backend.HttpCases[*backend.AgentResult, backend.ErrorJson]{
+		RequestsToSend:    []*backend.AgentResult{{ID: 0, Result: 1}},
+		ExpectedResponses: []backend.ErrorJson{backend.ErrorJsonFabric(backend.InvalidPayloadCode)},
+		HttpMethod:        "POST", UrlTarget: "/internal/task", ExpectedHttpCode: http.StatusUnprocessableEntity})
+	postBoolean(0, true)
+	var equal = expr.FabricReadyExprSendTask()
+	assert.Equal(t, "==", equal.Task.Operation)
+	postBoolean(equal.Task.PairID, false)
+	var not = expr.FabricReadyExprSendTask()
+	assert.Equal(t, false, not.Task.Arg1)
+	postBoolean(not.Task.PairID, true)
+	var and = expr.FabricReadyExprSendTask()
+	assert.Equal(t, true, and.Task.Arg1)
+	assert.Equal(t, true, and.Task.Arg2)
+	postBoolean(and.Task.PairID, true)
+
+	assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+	marshaled, err := expr.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `{"id": 0, "source": "2 < 3 && !(1 == 2)", "status": "Выполнено", "result": true,
+		"precision": "float64"}`, string(marshaled))
+}
+
+func testConditionalLazy(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	expr, _ := exprsList.ExprFabricAddInput(backend.ExpressionInput{Tree: parseTree(t, "if(1 > 0, 1 * 2, 1 / 0)")})
+	var tasks = expr.GetTasksHandler()
+	assert.Equal(t, 1, tasks.Len()) // ветви ждут условия.
+
+	var condition = expr.FabricReadyExprSendTask()
+	assert.Equal(t, ">", condition.Task.Operation)
+	assert.Equal(t, backend.ExprStatus(backend.NoReadyTasks), expr.Status)
+	assert.IsType(t, backend.TaskIDNotExist{}, expr.WriteResultIntoTask(pkg.Pair(0, 3), 2)) // задача-условие.
+	assert.Nil(t, expr.WriteBooleanResultIntoTask(condition.Task.PairID, true))
+	assert.Equal(t, backend.ExprStatus(backend.Ready), expr.Status)
+	assert.Equal(t, 2, tasks.Len()) // деление из невыбранной ветви агентам не достаётся.
+
+	var branch = expr.FabricReadyExprSendTask()
+	assert.Equal(t, "*", branch.Task.Operation)
+	assert.Equal(t, pkg.Pair(0, 1), branch.Task.PairID)
+	assert.Nil(t, expr.FabricReadyExprSendTask().Task)
+	assert.Nil(t, expr.WriteResultIntoTask(branch.Task.PairID, 2))
+	assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+	assert.Equal(t, float64(2), expr.Result)
+
+	t.Run("branch without tasks", func(t *testing.T) {
+		expr, _ := exprsList.ExprFabricAddInput(backend.ExpressionInput{Tree: parseTree(t, "if(1 < 0, 1 / 0, 5)")})
+		var condition = expr.FabricReadyExprSendTask()
+		assert.Nil(t, expr.WriteBooleanResultIntoTask(condition.Task.PairID, false))
+		assert.Equal(t, 1, expr.GetTasksHandler().Len())
+		assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+		assert.Equal(t, float64(5), expr.Result)
+	})
+
+	t.Run("shared subtree", func(t *testing.T) {
+		expr, _ := exprsList.ExprFabricAddInput(backend.ExpressionInput{Tree: parseTree(t,
+			"if(2 > 0, 2 + 1, 0) * (2 + 1)")})
+		var tasks = expr.GetTasksHandler()
+		assert.Equal(t, 3, tasks.Len())
+		var condition, sum = expr.FabricReadyExprSendTask(), expr.FabricReadyExprSendTask()
+		assert.Equal(t, "+", sum.Task.Operation)
+		assert.Nil(t, expr.WriteResultIntoTask(sum.Task.PairID, 3))
+		assert.Nil(t, expr.WriteBooleanResultIntoTask(condition.Task.PairID, true))
+		assert.Equal(t, 3, tasks.Len()) // ветвь 2 + 1 уже посчитана.
+		var product = expr.FabricReadyExprSendTask()
+		assert.Equal(t, float64(3), product.Task.Arg1)
+		assert.Equal(t, float64(3), product.Task.Arg2)
+	})
+}
+
+func testConditionalRestore(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var storagePath = filepath.Join(t.TempDir(), "expressions.jsonl")
+	storage, err := backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprsList, err = backend.ExpressionListFabricWithStorage(storage, backend.DefaultLeasePolicy,
+		backend.OperationTimesDefaultFabric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, _ := exprsList.ExprFabricAddInput(backend.ExpressionInput{Tree: parseTree(t,
+		"if(1 != 1, 2 ^ 10, 3 * 4) + 1")})
+	var condition = expr.FabricReadyExprSendTask()
+	assert.Nil(t, expr.WriteBooleanResultIntoTask(condition.Task.PairID, false))
+	if err = storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = backend.FileStorageFabric(storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Close()
+	})
+	exprsList, err = backend.ExpressionListFabricWithStorage(storage, backend.DefaultLeasePolicy,
+		backend.OperationTimesDefaultFabric())
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredExpr, ok := exprsList.Get(0)
+	if !ok {
+		t.Fatal("выражение 0 не восстановлено")
+	}
+	var tasks = restoredExpr.GetTasksHandler()
+	if assert.Equal(t, 2, tasks.Len()) { // условие посчитано, поэтому сразу создаётся ветвь 3 * 4.
+		assert.Equal(t, "*", tasks.Get(0).Operation)
+		assert.Equal(t, pkg.Pair(0, 2), tasks.Get(0).PairID)
+		assert.Equal(t, "+", tasks.Get(1).Operation)
+		assert.Equal(t, pkg.Pair(0, 4), tasks.Get(1).PairID)
+	}
+}
+
+func testConditional422(t *testing.T) {
+	var (
+		requestsToTest = []backend.RequestJson{{Expression: "1 + (2 < 3)"}, {Expression: "if(1, 2, 3)"},
+			{Expression: "if(1 < 2, 1 < 2, 3)"}, {Expression: "!1"}, {Expression: "1 < 2", Precision: "int64"},
+			{Expression: "a = 1", Variables: map[string]float64{"a": 1}}}
+		expectedResponses = []backend.ErrorJson{
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 4, Token: "2 < 3", Expected: "number",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 3, Token: "1", Expected: "boolean",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 17, Token: "3", Expected: "boolean",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 1, Token: "1", Expected: "boolean",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "<",
+				Expected: "operation supported in int64 precision", Err: pkg.UnsupportedOperation}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 2, Token: "=",
+				Expected: "operator or end of expression", Err: pkg.InvalidExpression}),
+		}
+	)
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, backend.ErrorJson]{
+		RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "POST",
+		UrlTarget: "/api/v1/calculate", ExpectedHttpCode: http.StatusUnprocessableEntity})
+	assert.Equal(t, backend.TypeMismatchCode, expectedResponses[0].Error.Code)
+}
+
+func TestConditional(t *testing.T) {
+	t.Run("TestLogical201", testLogical201)
+	t.Run("TestConditionalLazy", testConditionalLazy)
+	t.Run("TestConditionalRestore", testConditionalRestore)
+	t.Run("TestConditional422", testConditional422)
+}
+
+func testBitwise201(t *testing.T) {
+	t.Cleanup(func() {
+		exprsList = backend.ExpressionListEmptyFabric()
+	})
+	var times = backend.OperationTimesDefaultFabric()
+	times[backend.TIME_BITWISE_MS] = 3 * time.Second
+	exprsList.SetOperationTimes(times)
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, *ExpressionStub]{
+		RequestsToSend: []backend.RequestJson{{Expression: "0xF0 | 1 << n", Variables: map[string]float64{"n": 2}},
+			{Expression: "~0 xor 0xFF", Precision: "int64"}},
+		ExpectedResponses: []*ExpressionStub{{ID: 0}, {ID: 1}}, HttpMethod: "POST", UrlTarget: "/api/v1/calculate",
+		ExpectedHttpCode: http.StatusCreated})
+	exact, _ := exprsList.Get(1)
+	if tasks := exact.GetTasksHandler(); assert.Equal(t, 2, tasks.Len()) {
+		assert.Equal(t, pkg.BitwiseNot, tasks.Get(0).Operation)
+		assert.Equal(t, "0", tasks.Get(0).Arg1)
+		assert.Equal(t, pkg.BitwiseXor, tasks.Get(1).Operation)
+	}
+
+	expr, _ := exprsList.Get(0)
+	var shift = expr.FabricReadyExprSendTask()
+	buf, err := json.Marshal(shift)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, `{"task": {"id": 0, "arg1": 1, "arg2": 2, "operation": "<<", "operationTime": 3000000000}}`,
+		string(buf))
+	assert.Nil(t, expr.WriteResultIntoTask(shift.Task.PairID, 4))
+	var or = expr.FabricReadyExprSendTask()
+	assert.Equal(t, pkg.BitwiseOr, or.Task.Operation)
+	assert.Equal(t, 240.0, or.Task.Arg1)
+	assert.Equal(t, 4.0, or.Task.Arg2)
+	assert.Nil(t, expr.WriteResultIntoTask(or.Task.PairID, 244))
+	assert.Equal(t, backend.ExprStatus(backend.Completed), expr.Status)
+	assert.Equal(t, 244.0, expr.Result)
+}
+
+func testBitwise422(t *testing.T) {
+	var invalidVariable = backend.ErrorJsonFabric(backend.InvalidVariableCode)
+	invalidVariable.Error.Token = "xor"
+	var (
+		requestsToTest = []backend.RequestJson{{Expression: "1.5 & 1"},
+			{Expression: "x >> 1", Variables: map[string]float64{"x": 0.5}}, {Expression: "10 / 2 | 1"},
+			{Expression: "~(1 < 2)"}, {Expression: "3i & 1"}, {Expression: "pi xor 1"}, {Expression: "xor + 1"},
+			{Expression: "1", Variables: map[string]float64{"xor": 1}}, {Expression: "0xFFFFFFFFFFFFFFFF & 0xFF"},
+			{Expression: "1 | x", Variables: map[string]float64{"x": math.Pow(2, 60)}}}
+		expectedResponses = []backend.ErrorJson{
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "1.5", Expected: "integer",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "x", Expected: "integer",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "10 / 2", Expected: "integer",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 1, Token: "1 < 2", Expected: "integer",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "3i", Expected: "integer",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "pi", Expected: "integer",
+				Err: pkg.TypeMismatch}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "xor", Expected: expectedOperand,
+				Err: pkg.InvalidExpression}),
+			invalidVariable,
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 0, Token: "18446744073709551615",
+				Expected: "integer within ±2^53 in float64 precision", Err: pkg.NotAnInteger}),
+			backend.ParseErrorJsonFabric(pkg.ParseError{Position: 4, Token: "x",
+				Expected: "integer within ±2^53 in float64 precision", Err: pkg.NotAnInteger}),
+		}
+	)
+	testThroughHandler(calcHandler, t, backend.HttpCases[backend.RequestJson, backend.ErrorJson]{
+		RequestsToSend: requestsToTest, ExpectedResponses: expectedResponses, HttpMethod: "POST",
+		UrlTarget: "/api/v1/calculate", ExpectedHttpCode: http.StatusUnprocessableEntity})
+}
+
+func TestBitwise(t *testing.T) {
+	t.Run("TestBitwise201", testBitwise201)
+	t.Run("TestBitwise422", testBitwise422)
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	var (
+		w   = httptest.NewRecorder()
+		req = httptest.NewRequest("GET", "/api/v1/unknown", nil)
+	)
+	getHandler().ServeHTTP(w, req)
+	expected, err := backend.ErrorJsonFabric(backend.EndpointNotFoundCode).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, expected, w.Body.Bytes())
+}
+
 func TestInternalServerErrorHandler(t *testing.T) {
 	var (
 		w = httptest.NewRecorder()
@@ -1,9 +1,30 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
 func main() {
-	var err error
-	err = StartServer()
+	config, err := LoadConfig(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ошибка конфигурации:", err)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = StartServer(ctx, config)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "ошибка запуска оркестратора:", err)
+		stop() // os.Exit не выполняет отложенные вызовы.
+		os.Exit(1)
 	}
 }
@@ -1,7 +1,68 @@
 package main
 
-func StartServer() (err error) {
-	s := GetDefaultServer(getHandler())
-	err = s.ListenAndServe()
+import (
+	"context"
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"log"
+	"net/http"
+)
+
+// StartServer запускает оркестратор и блокируется до отмены ctx (SIGINT/SIGTERM) или ошибки сервера. После отмены
+// сервер перестаёт принимать соединения и за config.ShutdownTimeout дожидается уже начатых запросов, затем
+// останавливается проверка аренды и закрывается журнал выражений. Задачи, которые агенты не успели вернуть,
+// остаются в журнале отправленными и после перезапуска снова ставятся в очередь.
+func StartServer(ctx context.Context, config *Config) (err error) {
+	storage, err := backend.FileStorageFabric(config.StoragePath)
+	if err != nil {
+		return
+	}
+	defer func() {
+		err = errors.Join(err, storage.Close())
+	}()
+	exprsList, err = backend.ExpressionListFabricWithStorage(storage, config.LeasePolicy, config.OperationTimes)
+	if err != nil {
+		return
+	}
+	exprsList.SetMemoCache(backend.MemoCacheFabric(config.MemoCapacity))
+	constants, err = backend.ConstantsRegistryFabricWithFile(config.ConstantsPath)
+	if err != nil {
+		return
+	}
+	adminToken = config.AdminToken
+	reaperCtx, cancelReaper := context.WithCancel(context.Background())
+	var reaperDone = make(chan struct{})
+	go func() {
+		defer close(reaperDone)
+		exprsList.StartLeaseReaper(reaperCtx, config.LeaseReaperInterval)
+	}()
+	defer func() {
+		cancelReaper()
+		<-reaperDone
+	}()
+
+	var (
+		s        = GetServer(config, getHandler())
+		serveErr = make(chan error, 1)
+	)
+	go func() {
+		serveErr <- s.ListenAndServe()
+	}()
+	select {
+	case err = <-serveErr:
+		return
+	case <-ctx.Done():
+	}
+	log.Println("получен сигнал завершения, оркестратор останавливается")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancelShutdown()
+	err = s.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("не все запросы завершились за %s", config.ShutdownTimeout)
+		err = s.Close()
+	}
+	if serveErr := <-serveErr; !errors.Is(serveErr, http.ErrServerClosed) {
+		err = errors.Join(err, serveErr)
+	}
 	return
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"github.com/Debianov/calc-ya-go-24/backend"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getFreeAddr(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// TestStartServerShutdown проверяет, что после отмены контекста оркестратор завершается без ошибки, а принятые
+// до этого выражения остаются в журнале.
+func TestStartServerShutdown(t *testing.T) {
+	var savedConstants, savedAdminToken = constants, adminToken
+	t.Cleanup(func() { // StartServer заменяет глобальные переменные, а файл констант удаляется вместе с t.TempDir.
+		exprsList = backend.ExpressionListEmptyFabric()
+		constants, adminToken = savedConstants, savedAdminToken
+	})
+	var config = ConfigDefaultFabric()
+	config.Addr = getFreeAddr(t)
+	config.StoragePath = filepath.Join(t.TempDir(), "expressions.jsonl")
+	config.ConstantsPath = filepath.Join(t.TempDir(), "constants.json")
+	config.ShutdownTimeout = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var serverErr = make(chan error, 1)
+	go func() {
+		serverErr <- StartServer(ctx, config)
+	}()
+
+	var resp *http.Response
+	assert.Eventually(t, func() bool {
+		var err error
+		resp, err = http.Post("http://"+config.Addr+"/api/v1/calculate", "application/json",
+			strings.NewReader(`{"expression": "2+2"}`))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	if resp == nil {
+		t.FailNow()
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	cancel()
+	select {
+	case err := <-serverErr:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("оркестратор не остановился")
+	}
+	_, err := http.Get("http://" + config.Addr + "/api/v1/expressions")
+	assert.Error(t, err)
+
+	storage, err := backend.FileStorageFabric(config.StoragePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	records, err := storage.LoadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 1)
+}
@@ -0,0 +1,221 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/Debianov/calc-ya-go-24/pkg"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sync"
+)
+
+// ExpressionStorage — хранилище снимков выражений, на которое опирается ExpressionsList. Save вызывается после
+// каждого изменения выражения, LoadAll — один раз при запуске оркестратора.
+type ExpressionStorage interface {
+	Save(record ExpressionRecord) error
+	LoadAll() ([]ExpressionRecord, error)
+	Close() error
+}
+
+// ExpressionRecord — снимок выражения, по которому его можно восстановить после перезапуска. Граф задач не
+// сохраняется: он заново строится из Tree, после чего в него записываются TaskResults. Задачи, которые были
+// отправлены агентам, но не посчитаны, после восстановления снова становятся ReadyToCalc.
+type ExpressionRecord struct {
+	ID          int                `json:"id"`
+	Source      string             `json:"source,omitempty"`
+	Tree        *pkg.NodeJson      `json:"tree,omitempty"`
+	Postfix     []string           `json:"postfix,omitempty"` // вместо Tree в журналах, записанных до появления деревьев.
+	Status      ExprStatus         `json:"status"`
+	Result      float64            `json:"result"`
+	Value       string             `json:"value,omitempty"`
+	Fraction    string             `json:"fraction,omitempty"`
+	Imag        float64            `json:"imag,omitempty"`
+	Boolean     *bool              `json:"boolean,omitempty"`
+	Precision   pkg.Precision      `json:"precision,omitempty"`
+	Bits        uint               `json:"bits,omitempty"`
+	Error       *ErrorDetails      `json:"error,omitempty"`
+	Variables   map[string]float64 `json:"variables,omitempty"`
+	TasksSaved  int                `json:"tasksSaved,omitempty"`
+	TasksReused int                `json:"tasksReused,omitempty"`
+	TaskResults map[int]float64    `json:"taskResults,omitempty"` // PairID посчитанной задачи → её результат.
+	// ExactResults — то же, что TaskResults, для точных режимов: результаты там — строки (см. pkg.Precision).
+	ExactResults map[int]string `json:"exactResults,omitempty"`
+	// ComplexResults — то же для режима complex.
+	ComplexResults map[int]Complex `json:"complexResults,omitempty"`
+	// BooleanResults — то же для сравнений и логических операторов.
+	BooleanResults map[int]bool `json:"booleanResults,omitempty"`
+}
+
+// memoryStorage ничего не сохраняет. Используется, когда выражения не нужно переживать перезапуск (например, в
+// тестах).
+type memoryStorage struct{}
+
+func (m memoryStorage) Save(ExpressionRecord) error {
+	return nil
+}
+
+func (m memoryStorage) LoadAll() ([]ExpressionRecord, error) {
+	return nil, nil
+}
+
+func (m memoryStorage) Close() error {
+	return nil
+}
+
+func MemoryStorageFabric() ExpressionStorage {
+	return memoryStorage{}
+}
+
+// Журнал сжимается, когда строк в нём становится в compactRatio раз больше, чем выражений, но не раньше, чем их
+// наберётся compactMinLines: так каждое сжатие переписывает не больше половины прочитанного, а маленький журнал не
+// переписывается после каждого Save.
+const (
+	compactRatio    = 2
+	compactMinLines = 256
+)
+
+// fileStorage — журнал снимков выражений в формате JSON Lines: каждый Save дописывает в конец файла новую строку,
+// при чтении для каждого ID берётся последняя. При открытии, а потом по мере роста (см. compactRatio) журнал
+// сжимается до одной строки на выражение, поэтому его размер ограничен размером последних снимков.
+type fileStorage struct {
+	path  string
+	file  *os.File
+	mut   sync.Mutex
+	lines int              // строк в журнале.
+	ids   map[int]struct{} // выражения, у которых в журнале есть снимок.
+}
+
+// FileStorageFabric открывает (или создаёт) журнал по пути path.
+func FileStorageFabric(path string) (ExpressionStorage, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileStorage{path: path, file: file, ids: make(map[int]struct{})}, nil
+}
+
+func (f *fileStorage) Save(record ExpressionRecord) (err error) {
+	buf, err := json.Marshal(&record)
+	if err != nil {
+		return
+	}
+	buf = append(buf, '\n')
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	_, err = f.file.Write(buf)
+	if err != nil {
+		return
+	}
+	if err = f.file.Sync(); err != nil {
+		return
+	}
+	f.lines++
+	f.ids[record.ID] = struct{}{}
+	if f.lines < compactMinLines || f.lines < compactRatio*len(f.ids) {
+		return
+	}
+	records, err := f.read()
+	if err != nil {
+		return
+	}
+	return f.compact(records)
+}
+
+// LoadAll читает журнал и сжимает его. Недописанная последняя строка (оркестратор упал во время записи)
+// пропускается.
+func (f *fileStorage) LoadAll() (result []ExpressionRecord, err error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if result, err = f.read(); err != nil {
+		return
+	}
+	err = f.compact(result)
+	return
+}
+
+// read возвращает последний снимок каждого выражения в журнале по возрастанию ID. Вызывается под f.mut.
+func (f *fileStorage) read() (result []ExpressionRecord, err error) {
+	_, err = f.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return
+	}
+	var (
+		scanner = bufio.NewScanner(f.file)
+		records = make(map[int]ExpressionRecord)
+	)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record ExpressionRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			log.Printf("WARNING: пропущена повреждённая запись в %s: %s", f.path, err)
+			continue
+		}
+		records[record.ID] = record
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+	for _, record := range records {
+		result = append(result, record)
+	}
+	slices.SortFunc(result, func(a, b ExpressionRecord) int {
+		return a.ID - b.ID
+	})
+	return
+}
+
+// compact атомарно заменяет журнал файлом, в котором на каждое выражение приходится одна запись. Вызывается под
+// f.mut.
+func (f *fileStorage) compact(records []ExpressionRecord) (err error) {
+	var tmpPath = f.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return
+	}
+	var writer = bufio.NewWriter(tmpFile)
+	for _, record := range records {
+		var buf []byte
+		buf, err = json.Marshal(&record)
+		if err != nil {
+			return errors.Join(err, tmpFile.Close())
+		}
+		buf = append(buf, '\n')
+		if _, err = writer.Write(buf); err != nil {
+			return errors.Join(err, tmpFile.Close())
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return errors.Join(err, tmpFile.Close())
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return errors.Join(err, tmpFile.Close())
+	}
+	if err = tmpFile.Close(); err != nil {
+		return
+	}
+	if err = os.Rename(tmpPath, f.path); err != nil {
+		return
+	}
+	err = f.file.Close()
+	if err != nil {
+		return
+	}
+	f.file, err = os.OpenFile(f.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	f.lines, f.ids = len(records), make(map[int]struct{}, len(records))
+	for _, record := range records {
+		f.ids[record.ID] = struct{}{}
+	}
+	return
+}
+
+func (f *fileStorage) Close() error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	return f.file.Close()
+}
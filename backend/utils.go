@@ -1,28 +1,35 @@
 package backend
 
 import (
+	"context"
+	"github.com/Debianov/calc-ya-go-24/pkg"
 	"iter"
 	"maps"
+	"slices"
 	"sync"
 	"time"
 )
 
-// Tasks - обёртка над pkg.Stack с дополнительными методами. Нужен для обработки случаев, когда несколько Task-ов готовы
-// и нужно продолжить работу других Task-ов, зависящие от первых.
-// В случае, когда все необходимые Task-и обновлены, их результаты записываются в нужный Task, и дальше он отправляется
-// для дальнейшей обработки.
+// Tasks хранит граф задач одного выражения. Каждая Task знает задачи, аргументами которых станет её результат, и
+// номера этих аргументов, поэтому результат посчитанной задачи записывается по ссылке, а не по положению в срезе.
+// Задача попадает в очередь готовых, как только посчитаны все её дочерние задачи, так что независимые поддеревья
+// (например, обе части (1+2)*(3+4)) раздаются агентам параллельно.
 // Для работы с TaskToSend встроена структура.
 type Tasks struct {
 	*sentTasks
-	buf                                []*Task
-	tasksCountBeforeWaitingTask        int
-	updatedTasksCountBeforeWaitingTask int
-	mut                                sync.Mutex
+	buf   []*Task // все задачи в порядке создания; ветви условий pkg.Conditional создаются позже остальных.
+	ready []*Task // задачи, готовые к отправке агенту, в порядке появления.
+	byId  map[int]*Task
+	mut   sync.Mutex
 }
 
 func (t *Tasks) add(task *Task) {
 	t.mut.Lock()
 	t.buf = append(t.buf, task)
+	t.byId[task.PairID] = task
+	if task.IsReadyToCalc() {
+		t.ready = append(t.ready, task)
+	}
 	t.mut.Unlock()
 }
 
@@ -32,10 +39,11 @@ func (t *Tasks) Get(ind int) *Task {
 	return t.buf[ind]
 }
 
-func (t *Tasks) delete(ind int) {
+// getById возвращает задачу по PairID или nil, если такой задачи в графе нет.
+func (t *Tasks) getById(id int) *Task {
 	t.mut.Lock()
 	defer t.mut.Unlock()
-	t.buf = append(t.buf[:ind], t.buf[ind+1:]...)
+	return t.byId[id]
 }
 
 func (t *Tasks) Len() int {
@@ -44,70 +52,62 @@ func (t *Tasks) Len() int {
 	return len(t.buf)
 }
 
-// registerFirst возвращает первую задачу, не удаляет её, но регистрирует и не выдаёт ту же задачу в дальнейшем.
-// Удаляет в том случае, если задача не используется для вычисления других задач.
-// Для простого получения задачи используйте Get.
-func (t *Tasks) registerFirst() (task *Task) {
-	task = t.Get(t.tasksCountBeforeWaitingTask)
-	if task.IsReadyToCalc() {
-		t.tasksCountBeforeWaitingTask++
-		return
-	} else {
-		var expectedTask Task
-		if t.updatedTasksCountBeforeWaitingTask == t.tasksCountBeforeWaitingTask { // цикл в
-			// горутине не требуется, поскольку агент будут самостоятельно тыкать в сервер, чтоб тот проверил на
-			// наличие свободных таск
-			switch t.tasksCountBeforeWaitingTask {
-			case 1:
-				if _, ok := task.Arg1.(string); ok != true {
-					expectedTask = *t.Get(0)
-					t.delete(0)
-					task.Arg1 = expectedTask.result
-				}
-				t.updatedTasksCountBeforeWaitingTask = 0
-				t.tasksCountBeforeWaitingTask = 0
-			case 2:
-				if _, ok := task.Arg1.(string); ok != true {
-					expectedTask = *t.Get(0)
-					t.delete(0)
-					task.Arg1 = expectedTask.result
-				}
-				if _, ok := task.Arg2.(string); ok != true {
-					expectedTask = *t.Get(0)
-					t.delete(0)
-					task.Arg2 = expectedTask.result
-				}
-				t.updatedTasksCountBeforeWaitingTask = 0
-				t.tasksCountBeforeWaitingTask = 0
-			default:
-				if t.tasksCountBeforeWaitingTask < 3 {
-					break
-				}
-				calculatedTaskOffset := t.tasksCountBeforeWaitingTask
-				if _, ok := task.Arg2.(string); ok != true {
-					expectedTask = *t.Get(calculatedTaskOffset - 1)
-					t.delete(calculatedTaskOffset - 1)
-					task.Arg2 = expectedTask.result
-				}
-				if _, ok := task.Arg1.(string); ok != true {
-					expectedTask = *t.Get(calculatedTaskOffset - 2)
-					t.delete(calculatedTaskOffset - 2)
-					task.Arg1 = expectedTask.result
-				}
-				t.updatedTasksCountBeforeWaitingTask = t.updatedTasksCountBeforeWaitingTask - 2
-				t.tasksCountBeforeWaitingTask = t.tasksCountBeforeWaitingTask - 2 + 1 // -2 удалённых и +1 текущий, который
-				// теперь ReadyToCalc.
-			}
-			task.ChangeStatus(ReadyToCalc)
-		}
-		return
+// ReadyLen возвращает число задач, ожидающих отправки агенту.
+func (t *Tasks) ReadyLen() int {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return len(t.ready)
+}
+
+// registerReady достаёт первую готовую задачу из очереди и помечает её как Sent, чтобы она не была выдана
+// повторно. Возвращает nil, если готовых задач нет.
+func (t *Tasks) registerReady() (task *Task) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if len(t.ready) == 0 {
+		return nil
 	}
+	task = t.ready[0]
+	t.ready = t.ready[1:]
+	task.mut.Lock()
+	task.Status = Sent
+	task.attempts++
+	task.mut.Unlock()
+	return
+}
+
+// requeue возвращает в начало очереди задачи, аренда которых истекла, сохраняя их порядок: раньше отправленная
+// задача и снова отправится раньше.
+func (t *Tasks) requeue(tasks []*Task) {
+	for _, task := range tasks {
+		task.ChangeStatus(ReadyToCalc)
+	}
+	t.mut.Lock()
+	t.ready = append(slices.Clone(tasks), t.ready...)
+	t.mut.Unlock()
+}
+
+// pushReady ставит в очередь готовых задачу, у которой больше нет непосчитанных дочерних задач.
+func (t *Tasks) pushReady(task *Task) {
+	t.mut.Lock()
+	t.ready = append(t.ready, task)
+	t.mut.Unlock()
 }
 
-// CountUpdatedTask обновляет число отправленных тасок. Обязателен к вызову, если любой Task, указатель которого
-// хранится в экземпляре этой структуры, был обновлён.
-func (t *Tasks) CountUpdatedTask() {
-	t.updatedTasksCountBeforeWaitingTask++
+// getCalculatedResults возвращает результаты всех посчитанных задач по их PairID.
+func (t *Tasks) getCalculatedResults() map[int]interface{} {
+	t.mut.Lock()
+	var tasks = slices.Clone(t.buf)
+	t.mut.Unlock()
+	var result = make(map[int]interface{})
+	for _, task := range tasks {
+		task.mut.Lock()
+		if task.Status == Calculated {
+			result[task.PairID] = task.result
+		}
+		task.mut.Unlock()
+	}
+	return result
 }
 
 // sentTasks — map для работы с TaskToSend структурой.
@@ -116,10 +116,12 @@ type sentTasks struct {
 	mut sync.Mutex
 }
 
-func (t *sentTasks) TaskToSendFabricAdd(readyTask *Task, timeAtSendingTask time.Time) (result TaskToSend) {
+func (t *sentTasks) TaskToSendFabricAdd(readyTask *Task, timeAtSendingTask time.Time,
+	deadline time.Time) (result TaskToSend) {
 	result = TaskToSend{
 		Task:              readyTask,
 		timeAtSendingTask: timeAtSendingTask,
+		deadline:          deadline,
 	}
 	t.mut.Lock()
 	t.buf[readyTask.PairID] = result
@@ -127,14 +129,33 @@ func (t *sentTasks) TaskToSendFabricAdd(readyTask *Task, timeAtSendingTask time.
 	return
 }
 
-func (t *sentTasks) popSentTask(taskId int) (*Task, time.Time, bool) {
+func (t *sentTasks) popSentTask(taskId int) (TaskToSend, bool) {
 	t.mut.Lock()
 	taskWithTimer, ok := t.buf[taskId]
 	if ok {
 		delete(t.buf, taskId)
 	}
 	t.mut.Unlock()
-	return taskWithTimer.Task, taskWithTimer.timeAtSendingTask, ok
+	return taskWithTimer, ok
+}
+
+// popExpired снимает и возвращает задачи, аренда которых истекла к моменту now, в порядке отправки.
+func (t *sentTasks) popExpired(now time.Time) (result []TaskToSend) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	for taskId, taskWithTimer := range t.buf {
+		if now.After(taskWithTimer.deadline) {
+			result = append(result, taskWithTimer)
+			delete(t.buf, taskId)
+		}
+	}
+	slices.SortFunc(result, func(a, b TaskToSend) int {
+		if order := a.timeAtSendingTask.Compare(b.timeAtSendingTask); order != 0 {
+			return order
+		}
+		return a.Task.PairID - b.Task.PairID
+	})
+	return
 }
 
 func sentTasksFabric() *sentTasks {
@@ -145,29 +166,127 @@ func sentTasksFabric() *sentTasks {
 
 func TasksFabric() *Tasks {
 	newSentTasks := sentTasksFabric()
-	return &Tasks{sentTasks: newSentTasks}
+	return &Tasks{sentTasks: newSentTasks, byId: make(map[int]*Task)}
 }
 
 type ExpressionsList struct {
-	mut   sync.Mutex
-	exprs map[int]*Expression
+	mut            sync.Mutex
+	exprs          map[int]*Expression
+	nextId         int
+	storage        ExpressionStorage
+	leasePolicy    LeasePolicy
+	operationTimes OperationTimes
+	memo           *MemoCache // nil — результаты поддеревьев не кэшируются.
+}
+
+// ExpressionInput — выражение в том виде, в каком его принял оркестратор.
+type ExpressionInput struct {
+	Source     string             // текст выражения от клиента.
+	Tree       pkg.Node           // дерево, в которое pkg.Bind уже подставил значения Variables.
+	Variables  map[string]float64 // сохраняются только для того, чтобы вернуть их клиенту вместе с выражением.
+	TasksSaved int                // сколько задач убрал pkg.Simplify; 0, если дерево не упрощалось.
+	Precision  pkg.Precision      // пустой режим — pkg.Float64.
+	Bits       uint               // точность big.Float в режиме pkg.Big; 0 — pkg.DefaultBits.
 }
 
-func (e *ExpressionsList) ExprFabricAdd(postfix []string) (newExpr *Expression, newId int) {
+// ExprFabricAdd добавляет выражение по дереву, которое вернул pkg.Parse. Переменных в дереве быть не должно.
+// Исходным текстом выражения считается канонический вид дерева.
+func (e *ExpressionsList) ExprFabricAdd(tree pkg.Node) (newExpr *Expression, newId int) {
+	return e.ExprFabricAddInput(ExpressionInput{Source: pkg.Format(tree), Tree: tree})
+}
+
+// ExprFabricAddInput добавляет выражение, принятое от клиента, и разбивает его дерево на задачи.
+func (e *ExpressionsList) ExprFabricAddInput(input ExpressionInput) (newExpr *Expression, newId int) {
 	newId = e.generateId()
 	newTaskSpace := TasksFabric()
-	newExpr = &Expression{postfix: postfix, ID: newId, Status: Ready, tasksHandler: newTaskSpace}
+	if input.Precision == "" {
+		input.Precision = pkg.Float64
+	}
+	if input.Precision == pkg.Big && input.Bits == 0 {
+		input.Bits = pkg.DefaultBits
+	}
+	newExpr = &Expression{tree: input.Tree, ID: newId, Source: input.Source, Status: Ready,
+		Precision: input.Precision, Bits: input.Bits, Variables: input.Variables, TasksSaved: input.TasksSaved,
+		tasksHandler: newTaskSpace,
+		leasePolicy:  e.getLeasePolicy(), operationTimes: e.getOperationTimes(), memo: e.GetMemoCache(),
+		storage: e.storage}
 	newExpr.DivideIntoTasks()
 	e.mut.Lock()
 	e.exprs[newId] = newExpr
 	e.mut.Unlock()
+	newExpr.persist()
 	return
 }
 
+// SetMemoCache задаёт кэш результатов поддеревьев для выражений, добавленных после вызова.
+func (e *ExpressionsList) SetMemoCache(memo *MemoCache) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.memo = memo
+}
+
+// GetMemoCache возвращает кэш результатов поддеревьев или nil, если он не задан.
+func (e *ExpressionsList) GetMemoCache() *MemoCache {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.memo
+}
+
+// SetLeasePolicy задаёт аренду задач для выражений, добавленных после вызова.
+func (e *ExpressionsList) SetLeasePolicy(policy LeasePolicy) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.leasePolicy = policy
+}
+
+func (e *ExpressionsList) getLeasePolicy() LeasePolicy {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.leasePolicy
+}
+
+// SetOperationTimes задаёт время операций для выражений, добавленных после вызова.
+func (e *ExpressionsList) SetOperationTimes(times OperationTimes) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.operationTimes = times
+}
+
+func (e *ExpressionsList) getOperationTimes() OperationTimes {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	return e.operationTimes
+}
+
+// ReapExpiredTasks возвращает в очередь задачи всех выражений, аренда которых истекла к моменту now.
+func (e *ExpressionsList) ReapExpiredTasks(now time.Time) {
+	for _, expr := range e.GetAllExprs() {
+		if expr.tasksHandler != nil {
+			expr.ReapExpiredTasks(now)
+		}
+	}
+}
+
+// StartLeaseReaper раз в interval проверяет аренду отправленных задач, пока не будет отменён ctx.
+func (e *ExpressionsList) StartLeaseReaper(ctx context.Context, interval time.Duration) {
+	var ticker = time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.ReapExpiredTasks(now)
+		}
+	}
+}
+
 func (e *ExpressionsList) generateId() (id int) {
 	e.mut.Lock()
 	defer e.mut.Unlock()
-	return len(e.exprs)
+	id = e.nextId
+	e.nextId++
+	return
 }
 
 // GetAllExprs выдаёт значения в рандомном порядке.
@@ -207,7 +326,7 @@ func (e *ExpressionsList) GetReadyExpr() (expr *Expression) {
 	e.mut.Lock()
 	defer e.mut.Unlock()
 	for _, v := range e.exprs {
-		if v.Status == Ready {
+		if v.getStatus() == Ready {
 			return v
 		}
 	}
@@ -216,18 +335,53 @@ func (e *ExpressionsList) GetReadyExpr() (expr *Expression) {
 
 func ExpressionListEmptyFabric() *ExpressionsList {
 	return &ExpressionsList{
-		mut:   sync.Mutex{},
-		exprs: make(map[int]*Expression),
+		mut:            sync.Mutex{},
+		exprs:          make(map[int]*Expression),
+		storage:        MemoryStorageFabric(),
+		leasePolicy:    DefaultLeasePolicy,
+		operationTimes: OperationTimesDefaultFabric(),
 	}
 }
 
 func ExpressionListFabricWithElements(exprs []*Expression) *ExpressionsList {
-	var result = make(map[int]*Expression)
+	var (
+		result = make(map[int]*Expression)
+		nextId int
+	)
 	for _, expr := range exprs {
 		result[expr.ID] = expr
+		nextId = max(nextId, expr.ID+1)
 	}
 	return &ExpressionsList{
-		mut:   sync.Mutex{},
-		exprs: result,
+		mut:            sync.Mutex{},
+		exprs:          result,
+		nextId:         nextId,
+		storage:        MemoryStorageFabric(),
+		leasePolicy:    DefaultLeasePolicy,
+		operationTimes: OperationTimesDefaultFabric(),
+	}
+}
+
+// ExpressionListFabricWithStorage восстанавливает выражения из storage и сохраняет в него все последующие
+// изменения. Задачи, которые были отправлены агентам до перезапуска, снова ставятся в очередь.
+func ExpressionListFabricWithStorage(storage ExpressionStorage, leasePolicy LeasePolicy,
+	operationTimes OperationTimes) (*ExpressionsList, error) {
+	records, err := storage.LoadAll()
+	if err != nil {
+		return nil, err
+	}
+	var result = &ExpressionsList{
+		mut:            sync.Mutex{},
+		exprs:          make(map[int]*Expression),
+		storage:        storage,
+		leasePolicy:    leasePolicy,
+		operationTimes: operationTimes,
+	}
+	for _, record := range records {
+		if result.exprs[record.ID], err = restoreExpression(record, storage, leasePolicy, operationTimes); err != nil {
+			return nil, err
+		}
+		result.nextId = max(result.nextId, record.ID+1)
 	}
+	return result, nil
 }
@@ -0,0 +1,232 @@
+package pkg
+
+import (
+	"fmt"
+)
+
+// Span — участок исходного выражения [Start, End) в байтах, из которого получился узел.
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Node — узел дерева выражения, которое строит Parse. Реализации: *NumberNode, *VariableNode, *UnaryNode,
+// *BinaryNode и *CallNode. Скобки отдельных узлов не образуют: их роль играет структура дерева, а участок узла в
+// скобках включает и сами скобки.
+type Node interface {
+	Span() Span
+	node()
+}
+
+// NumberNode — число. Name — имя переменной или константы, вместо которой Bind подставил Value; у числа,
+// записанного в выражении, оно пустое. Exact — десятичная запись литерала, если float64 передаёт его неточно и
+// FormatNumber(Value) дала бы другое число (12345678901234567890123, 0.1000000000000000000001); её используют
+// точные режимы Precision. Обычно Exact пустая. Imag — мнимая часть у мнимого литерала (4i) и константы
+// ImaginaryUnit; Value у них нулевое, так что число всегда либо вещественное, либо чисто мнимое.
+type NumberNode struct {
+	Value float64
+	Imag  float64
+	Exact string
+	Name  string
+	Pos   Span
+}
+
+// VariableNode — имя переменной или константы. Bind заменяет его на NumberNode со значением.
+type VariableNode struct {
+	Name string
+	Pos  Span
+}
+
+// UnaryNode — унарный оператор; Operator — UnaryMinus, UnaryPlus, LogicalNot или BitwiseNot.
+type UnaryNode struct {
+	Operator string
+	Operand  Node
+	Pos      Span
+}
+
+// BinaryNode — бинарный оператор. Operator — один из тех, что узнаёт IsOperator; PowerAlias приводится к Power.
+type BinaryNode struct {
+	Operator string
+	Left     Node
+	Right    Node
+	Pos      Span
+}
+
+// CallNode — вызов встроенной функции. Число аргументов проверено при разборе.
+type CallNode struct {
+	Function string
+	Args     []Node
+	Pos      Span
+}
+
+func (n *NumberNode) Span() Span   { return n.Pos }
+func (n *VariableNode) Span() Span { return n.Pos }
+func (n *UnaryNode) Span() Span    { return n.Pos }
+func (n *BinaryNode) Span() Span   { return n.Pos }
+func (n *CallNode) Span() Span     { return n.Pos }
+
+func (n *NumberNode) node()   {}
+func (n *VariableNode) node() {}
+func (n *UnaryNode) node()    {}
+func (n *BinaryNode) node()   {}
+func (n *CallNode) node()     {}
+
+func setSpan(node Node, span Span) {
+	switch n := node.(type) {
+	case *NumberNode:
+		n.Pos = span
+	case *VariableNode:
+		n.Pos = span
+	case *UnaryNode:
+		n.Pos = span
+	case *BinaryNode:
+		n.Pos = span
+	case *CallNode:
+		n.Pos = span
+	}
+}
+
+// Parse разбирает выражение в дерево. Имена переменных остаются в дереве как VariableNode; чтобы подставить
+// значения, дерево передаётся в Bind. В случае ошибки возвращается ParseError с позицией проблемного токена; типы
+// операндов проверяются уже по дереву (см. CheckTypes).
+func Parse(expression string) (Node, error) {
+	tree, err := parse(tokenize(expression), len(expression))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = CheckTypes(tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Bind возвращает копию node, в которой каждая переменная заменена числом из lookup, а если там имени нет —
+// значением встроенной константы. Переменная без значения даёт ParseError с UnboundVariable; при нескольких таких
+// переменных сообщается о самой левой. lookup может быть nil. Теперь, когда значения известны, типы проверяются ещё
+// раз (см. CheckTypes): переменная с дробным значением под побитовым оператором — TypeMismatch.
+func Bind(node Node, lookup VariableLookup) (Node, error) {
+	result, err := bind(node, lookup)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = CheckTypes(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func bind(node Node, lookup VariableLookup) (Node, error) {
+	switch n := node.(type) {
+	case *VariableNode:
+		if lookup != nil {
+			if value, ok := lookup(n.Name); ok {
+				return &NumberNode{Value: value, Name: n.Name, Pos: n.Pos}, nil
+			}
+		}
+		if value, ok := GetConstant(n.Name); ok {
+			return &NumberNode{Value: value, Name: n.Name, Pos: n.Pos}, nil
+		}
+		if n.Name == ImaginaryUnit {
+			return &NumberNode{Imag: 1, Name: n.Name, Pos: n.Pos}, nil
+		}
+		return nil, ParseError{Position: n.Pos.Start, Token: n.Name,
+			Expected: fmt.Sprintf("value for '%s' in variables", n.Name), Err: UnboundVariable}
+	case *UnaryNode:
+		operand, err := bind(n.Operand, lookup)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Operator: n.Operator, Operand: operand, Pos: n.Pos}, nil
+	case *BinaryNode:
+		left, err := bind(n.Left, lookup)
+		if err != nil {
+			return nil, err
+		}
+		right, err := bind(n.Right, lookup)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryNode{Operator: n.Operator, Left: left, Right: right, Pos: n.Pos}, nil
+	case *CallNode:
+		var args = make([]Node, len(n.Args))
+		for ind, arg := range n.Args {
+			var err error
+			if args[ind], err = bind(arg, lookup); err != nil {
+				return nil, err
+			}
+		}
+		return &CallNode{Function: n.Function, Args: args, Pos: n.Pos}, nil
+	default:
+		return node, nil
+	}
+}
+
+// Postfix переводит дерево в постфиксную запись: числа записываются через FormatNumber (или Exact), вызовы функций — через
+// FunctionCallToken, оставшиеся переменные — своими именами. Порядок операторов в постфиксе совпадает с обходом
+// дерева в глубину слева направо.
+func Postfix(node Node) (result []string) {
+	switch n := node.(type) {
+	case *NumberNode:
+		result = append(result, formatNumberValue(n))
+	case *VariableNode:
+		result = append(result, n.Name)
+	case *UnaryNode:
+		result = append(Postfix(n.Operand), n.Operator)
+	case *BinaryNode:
+		result = append(append(Postfix(n.Left), Postfix(n.Right)...), n.Operator)
+	case *CallNode:
+		for _, arg := range n.Args {
+			result = append(result, Postfix(arg)...)
+		}
+		result = append(result, FunctionCallToken(n.Function, len(n.Args)))
+	}
+	return
+}
+
+// ParsePostfix строит дерево из постфикса, записанного Postfix (например, сохранённого в журнал). Позиций в
+// постфиксе нет, поэтому у всех узлов пустой Span.
+func ParsePostfix(postfix []string) (Node, error) {
+	var stack []Node
+	var pop = func(count int) []Node {
+		var result = stack[len(stack)-count:]
+		stack = stack[:len(stack)-count]
+		return result
+	}
+	for _, item := range postfix {
+		var (
+			name, argsCount, isCall = ParseFunctionCall(item)
+			operandsCount           = 0
+		)
+		switch {
+		case isCall:
+			operandsCount = argsCount
+		case IsUnaryOperator(item):
+			operandsCount = 1
+		case IsOperator(item):
+			operandsCount = 2
+		}
+		if len(stack) < operandsCount {
+			return nil, fmt.Errorf("%w: not enough operands for '%s' in postfix", InvalidExpression, item)
+		}
+		switch {
+		case isCall:
+			stack = append(stack, &CallNode{Function: name, Args: append([]Node(nil), pop(argsCount)...)})
+		case IsUnaryOperator(item):
+			stack = append(stack, &UnaryNode{Operator: item, Operand: pop(1)[0]})
+		case IsOperator(item):
+			var operands = pop(2)
+			stack = append(stack, &BinaryNode{Operator: item, Left: operands[0], Right: operands[1]})
+		case IsNumberLiteral(item):
+			number, _ := parseNumberNode(item, Span{})
+			stack = append(stack, number)
+		case IsVariableName(item):
+			stack = append(stack, &VariableNode{Name: item})
+		default:
+			return nil, fmt.Errorf("%w: unexpected '%s' in postfix", InvalidExpression, item)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("%w: postfix leaves %d values instead of 1", InvalidExpression, len(stack))
+	}
+	return stack[0], nil
+}
@@ -0,0 +1,169 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// NodeJson — обёртка, которая записывает дерево в JSON и читает его обратно. Каждый узел — объект с полем type
+// ("number", "variable", "unary", "binary" или "call") и span; остальные поля зависят от типа:
+//
+//	{"type": "binary", "operator": "+", "left": {...}, "right": {...}, "span": {"start": 0, "end": 5}}
+//	{"type": "number", "value": 2, "name": "a", "span": {...}}
+//	{"type": "number", "value": 1.2345678901234568e+22, "exact": "12345678901234567890123", "span": {...}}
+//	{"type": "number", "value": 0, "imag": 4, "span": {...}}
+//	{"type": "call", "function": "max", "args": [...], "span": {...}}
+//
+// Бесконечное значение числа записывается строкой "+Inf" или "-Inf", поскольку в JSON нет чисел для него.
+type NodeJson struct {
+	Node Node
+}
+
+type nodeJson struct {
+	Type     string      `json:"type"`
+	Value    *jsonNumber `json:"value,omitempty"`
+	Imag     float64     `json:"imag,omitempty"`
+	Exact    string      `json:"exact,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Operator string      `json:"operator,omitempty"`
+	Function string      `json:"function,omitempty"`
+	Operand  *nodeJson   `json:"operand,omitempty"`
+	Left     *nodeJson   `json:"left,omitempty"`
+	Right    *nodeJson   `json:"right,omitempty"`
+	Args     []*nodeJson `json:"args,omitempty"`
+	Span     Span        `json:"span"`
+}
+
+type jsonNumber float64
+
+func (j jsonNumber) MarshalJSON() ([]byte, error) {
+	if math.IsInf(float64(j), 0) {
+		return json.Marshal(FormatNumber(float64(j)))
+	}
+	return json.Marshal(float64(j))
+}
+
+func (j *jsonNumber) UnmarshalJSON(buf []byte) error {
+	var value float64
+	if err := json.Unmarshal(buf, &value); err == nil {
+		*j = jsonNumber(value)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(buf, &text); err != nil {
+		return err
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil || !math.IsInf(value, 0) {
+		return fmt.Errorf("%w: unexpected number %s in tree", InvalidExpression, buf)
+	}
+	*j = jsonNumber(value)
+	return nil
+}
+
+func (n NodeJson) MarshalJSON() ([]byte, error) {
+	if n.Node == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(toNodeJson(n.Node))
+}
+
+func (n *NodeJson) UnmarshalJSON(buf []byte) (err error) {
+	var decoded nodeJson
+	if err = json.Unmarshal(buf, &decoded); err != nil {
+		return
+	}
+	n.Node, err = fromNodeJson(&decoded)
+	return
+}
+
+func toNodeJson(node Node) *nodeJson {
+	var result = &nodeJson{Span: node.Span()}
+	switch n := node.(type) {
+	case *NumberNode:
+		var value = jsonNumber(n.Value)
+		result.Type, result.Value, result.Imag, result.Exact, result.Name = "number", &value, n.Imag, n.Exact, n.Name
+	case *VariableNode:
+		result.Type, result.Name = "variable", n.Name
+	case *UnaryNode:
+		result.Type, result.Operator, result.Operand = "unary", n.Operator, toNodeJson(n.Operand)
+	case *BinaryNode:
+		result.Type, result.Operator = "binary", n.Operator
+		result.Left, result.Right = toNodeJson(n.Left), toNodeJson(n.Right)
+	case *CallNode:
+		result.Type, result.Function = "call", n.Function
+		result.Args = make([]*nodeJson, 0, len(n.Args))
+		for _, arg := range n.Args {
+			result.Args = append(result.Args, toNodeJson(arg))
+		}
+	}
+	return result
+}
+
+// fromNodeJson проверяет узел так же строго, как Parse: неизвестные операторы, функции и неверное число
+// аргументов дают ошибку с InvalidExpression.
+func fromNodeJson(decoded *nodeJson) (Node, error) {
+	if decoded == nil {
+		return nil, fmt.Errorf("%w: missing node in tree", InvalidExpression)
+	}
+	switch decoded.Type {
+	case "number":
+		if decoded.Value == nil {
+			return nil, fmt.Errorf("%w: number without value in tree", InvalidExpression)
+		}
+		if decoded.Exact != "" {
+			if _, err := ParseExactNumber(decoded.Exact); err != nil {
+				return nil, fmt.Errorf("%w: invalid exact value '%s' in tree", InvalidExpression, decoded.Exact)
+			}
+		}
+		if decoded.Imag != 0 && (*decoded.Value != 0 || decoded.Exact != "") {
+			return nil, fmt.Errorf("%w: number with both real and imaginary parts in tree", InvalidExpression)
+		}
+		return &NumberNode{Value: float64(*decoded.Value), Imag: decoded.Imag, Exact: decoded.Exact,
+			Name: decoded.Name, Pos: decoded.Span}, nil
+	case "variable":
+		if !IsVariableName(decoded.Name) {
+			return nil, fmt.Errorf("%w: invalid variable '%s' in tree", InvalidExpression, decoded.Name)
+		}
+		return &VariableNode{Name: decoded.Name, Pos: decoded.Span}, nil
+	case "unary":
+		if !IsUnaryOperator(decoded.Operator) {
+			return nil, fmt.Errorf("%w: unknown unary operator '%s' in tree", InvalidExpression, decoded.Operator)
+		}
+		operand, err := fromNodeJson(decoded.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryNode{Operator: decoded.Operator, Operand: operand, Pos: decoded.Span}, nil
+	case "binary":
+		if !IsOperator(decoded.Operator) {
+			return nil, fmt.Errorf("%w: unknown operator '%s' in tree", InvalidExpression, decoded.Operator)
+		}
+		left, err := fromNodeJson(decoded.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := fromNodeJson(decoded.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryNode{Operator: decoded.Operator, Left: left, Right: right, Pos: decoded.Span}, nil
+	case "call":
+		if arity, ok := GetFunctionArity(decoded.Function); !ok || !arity.Accepts(len(decoded.Args)) {
+			return nil, fmt.Errorf("%w: invalid call of '%s' in tree", InvalidExpression, decoded.Function)
+		}
+		var args = make([]Node, 0, len(decoded.Args))
+		for _, arg := range decoded.Args {
+			node, err := fromNodeJson(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, node)
+		}
+		return &CallNode{Function: decoded.Function, Args: args, Pos: decoded.Span}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown node type '%s' in tree", InvalidExpression, decoded.Type)
+	}
+}
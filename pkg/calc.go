@@ -1,115 +1,334 @@
 package pkg
 
 import (
-	"errors"
+	"slices"
+	"strconv"
 	"strings"
 )
 
-func GeneratePostfix(expression string) (result []string, isValid bool) {
-	if len(expression) == 0 {
-		return nil, true
-	}
-	tokens := tokenize(expression)
-	postfix, err := translateToPostfix(tokens)
+// GeneratePostfix переводит выражение в постфиксную запись. В случае ошибки возвращается ParseError с
+// позицией проблемного токена. Из имён в выражении допустимы только встроенные константы.
+func GeneratePostfix(expression string) (result []string, err error) {
+	return GeneratePostfixWithVariables(expression, nil)
+}
+
+// VariableLookup возвращает значение переменной name; ok == false, если значение не задано.
+type VariableLookup func(name string) (value float64, ok bool)
+
+// GeneratePostfixWithVariables — GeneratePostfix, в котором вместо имён переменных в постфикс подставляются их
+// значения из lookup, а если там имени нет — значения встроенных констант. Имя без значения даёт ParseError с
+// UnboundVariable. lookup может быть nil. То же самое, что Parse, Bind и Postfix по очереди.
+func GeneratePostfixWithVariables(expression string, lookup VariableLookup) (result []string, err error) {
+	tree, err := Parse(expression)
 	if err != nil {
-		return nil, false
+		return
 	}
-	return postfix, true
+	if tree, err = Bind(tree, lookup); err != nil {
+		return
+	}
+	return Postfix(tree), nil
+}
+
+// FormatNumber записывает число так, чтобы ParseNumber вернул то же значение.
+func FormatNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
 }
 
-func tokenize(expr string) []string {
+// IsVariableName сообщает, может ли name быть именем переменной. Имена операторов (neg, pos, xor) зарезервированы.
+func IsVariableName(name string) bool {
+	return IsIdentifier(name) && !IsUnaryOperator(name) && !IsOperator(name)
+}
+
+// longOperators — операторы из двух символов. Токенизатор выбирает самый длинный из подходящих: "<=" — один токен,
+// а не "<" и "=", "&&" — не два "&".
+var longOperators = []string{LessEqual, GreaterEqual, Equal, NotEqual, LogicalAnd, LogicalOr, ShiftLeft, ShiftRight}
+
+// token — лексема выражения вместе с её положением в исходной строке.
+type token struct {
+	value    string
+	position int  // смещение первого байта лексемы в выражении.
+	unary    bool // + или -, которые tokenize записал как UnaryPlus или UnaryMinus.
+}
+
+func tokenize(expr string) []token {
 	var (
-		tokens       []string
+		tokens       []token
 		currentToken strings.Builder
+		currentStart int
+		skipUntil    int // символы до этого смещения уже вошли в токен (PowerAlias, IntDivision, число и т.п.).
+		flushCurrent = func() {
+			if currentToken.Len() > 0 {
+				tokens = append(tokens, token{value: currentToken.String(), position: currentStart})
+				currentToken.Reset()
+			}
+		}
 	)
 
-	for _, char := range expr {
+	for ind, char := range expr {
+		if ind < skipUntil {
+			continue
+		}
 		switch char {
 		case ' ':
-			continue
-		case '+', '-', '*', '/', '(', ')':
-			if currentToken.Len() > 0 {
-				tokens = append(tokens, currentToken.String())
-				currentToken.Reset()
+			flushCurrent()
+		case '+', '-':
+			flushCurrent()
+			if isUnaryPosition(tokens) {
+				tokens = append(tokens, token{value: getUnaryOperator(char), position: ind, unary: true})
+			} else {
+				tokens = append(tokens, token{value: string(char), position: ind})
 			}
-			tokens = append(tokens, string(char))
+		case '*':
+			flushCurrent()
+			if strings.HasPrefix(expr[ind:], PowerAlias) {
+				tokens = append(tokens, token{value: PowerAlias, position: ind})
+				skipUntil = ind + len(PowerAlias)
+			} else {
+				tokens = append(tokens, token{value: string(char), position: ind})
+			}
+		case '/':
+			flushCurrent()
+			if strings.HasPrefix(expr[ind:], IntDivision) {
+				tokens = append(tokens, token{value: IntDivision, position: ind})
+				skipUntil = ind + len(IntDivision)
+			} else {
+				tokens = append(tokens, token{value: string(char), position: ind})
+			}
+		case '%', '^', '~', '(', ')', ',':
+			flushCurrent()
+			tokens = append(tokens, token{value: string(char), position: ind})
+		case '<', '>', '=', '!', '&', '|':
+			flushCurrent()
+			var value = string(char)
+			if operator := expr[ind:min(ind+2, len(expr))]; slices.Contains(longOperators, operator) {
+				value = operator
+			}
+			tokens = append(tokens, token{value: value, position: ind})
+			skipUntil = ind + len(value)
 		default:
+			if currentToken.Len() == 0 && isNumberStart(char) {
+				skipUntil = scanNumber(expr, ind)
+				tokens = append(tokens, token{value: expr[ind:skipUntil], position: ind})
+				continue
+			}
+			if currentToken.Len() == 0 {
+				currentStart = ind
+			}
 			currentToken.WriteRune(char)
 		}
 	}
+	flushCurrent()
+
+	return tokens
+}
+
+// isUnaryPosition сообщает, будет ли + или -, идущий после tokens, унарным: в начале выражения, после (, после
+// запятой или после другого оператора.
+func isUnaryPosition(tokens []token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	var last = tokens[len(tokens)-1].value
+	return last == "(" || last == "," || isBinaryOperator(last) || IsUnaryOperator(last)
+}
 
-	if currentToken.Len() > 0 {
-		tokens = append(tokens, currentToken.String())
+// isBinaryOperator, в отличие от IsOperator, узнаёт и PowerAlias, который встречается только во входном выражении.
+func isBinaryOperator(value string) bool {
+	return IsOperator(value) || value == PowerAlias
+}
+
+func getUnaryOperator(char rune) string {
+	if char == '-' {
+		return UnaryMinus
 	}
+	return UnaryPlus
+}
 
-	return tokens
+// parenFrame — открытая скобка. Для скобки вызова функции function — имя функции, а argsCount — число уже
+// разобранных аргументов; для обычной скобки function пустой.
+type parenFrame struct {
+	function  token
+	argsCount int
+}
+
+func (p parenFrame) isCall() bool {
+	return p.function.value != ""
 }
 
-func translateToPostfix(tokens []string) ([]string, error) {
+// parse — алгоритм сортировочной станции, который вместо постфикса собирает дерево: операнды копятся в стеке
+// узлов, а при выталкивании оператора из стека операторов его операнды снимаются со стека узлов. Разбор идёт по
+// двум состояниям: ожидается операнд (число, переменная, вызов функции, ( или унарный оператор) либо оператор
+// (бинарный оператор, запятая или )). Токен, не подходящий под текущее состояние, сразу даёт ParseError.
+func parse(tokens []token, exprLen int) (Node, error) {
 	var (
-		output              []string
-		operators           = StackFabric[string]()
-		operandCount        int
-		operatorCount       int
-		firstMustBeOperator bool // после любой ) должен идти только оператор. С помощью этого флага мы будем проверять
-		// на наличие этого условия.
+		nodes         []Node
+		operators     = StackFabric[token]()
+		expectOperand = true
+		frames        []parenFrame
 	)
+	var popNodes = func(count int) []Node {
+		var result = append([]Node(nil), nodes[len(nodes)-count:]...)
+		nodes = nodes[:len(nodes)-count]
+		return result
+	}
+	var reduce = func() {
+		var op = operators.Pop()
+		if IsUnaryOperator(op.value) {
+			var operand = popNodes(1)[0]
+			nodes = append(nodes, &UnaryNode{Operator: op.value, Operand: operand,
+				Pos: Span{Start: op.position, End: operand.Span().End}})
+			return
+		}
+		var operands = popNodes(2)
+		nodes = append(nodes, &BinaryNode{Operator: op.value, Left: operands[0], Right: operands[1],
+			Pos: Span{Start: operands[0].Span().Start, End: operands[1].Span().End}})
+	}
+	var reduceUntilParen = func() {
+		for operators.GetLast().value != "(" {
+			reduce()
+		}
+	}
 
-	for _, token := range tokens {
-		if IsNumber(token) {
-			if firstMustBeOperator {
-				return nil, InvalidExpression
+	for ind := 0; ind < len(tokens); ind++ {
+		var tok = tokens[ind]
+		var tokSpan = Span{Start: tok.position, End: tok.position + len(tok.value)}
+		if expectOperand {
+			switch {
+			case isNumberStart(rune(tok.value[0])):
+				number, err := parseNumberNode(tok.value, tokSpan)
+				if err != nil {
+					return nil, newUnexpectedTokenError(tok, getExpectedNumber(err), err)
+				}
+				nodes = append(nodes, number)
+				expectOperand = false
+			case tok.value == "(":
+				operators.Push(tok)
+				frames = append(frames, parenFrame{})
+			// Унарный оператор префиксный, поэтому ничего не выталкивает из стека. Имена UnaryMinus и UnaryPlus — только
+			// для постфикса: написанные в выражении словом, они не оператор.
+			case IsUnaryOperator(tok.value) && (tok.unary || !IsIdentifier(tok.value)):
+				operators.Push(tok)
+			case IsIdentifier(tok.value) && ind+1 < len(tokens) && tokens[ind+1].value == "(":
+				if _, ok := GetFunctionArity(tok.value); !ok {
+					return nil, newUnexpectedTokenError(tok, "function name", UnknownFunction)
+				}
+				ind++ // скобка вызова относится к функции.
+				operators.Push(tokens[ind])
+				frames = append(frames, parenFrame{function: tok})
+			case IsVariableName(tok.value):
+				nodes = append(nodes, &VariableNode{Name: tok.value, Pos: tokSpan})
+				expectOperand = false
+			default:
+				return nil, newUnexpectedTokenError(tok, expectedOperand, InvalidExpression)
+			}
+			continue
+		}
+		switch {
+		case tok.value == ")":
+			if len(frames) == 0 {
+				return nil, newUnexpectedTokenError(tok, getExpectedOperator(frames), MismatchedParentheses)
 			}
-			output = append(output, token)
-			operandCount++
-		} else if token == "(" {
-			if firstMustBeOperator {
-				return nil, InvalidExpression
+			var frame = frames[len(frames)-1]
+			if frame.isCall() {
+				frame.argsCount++
+				if arity, _ := GetFunctionArity(frame.function.value); !arity.Accepts(frame.argsCount) {
+					return nil, newUnexpectedTokenError(tok, getExpectedArgs(frame.function.value, arity),
+						WrongArgumentsCount)
+				}
 			}
-			operators.Push(token)
-		} else if token == ")" {
-			for operators.Len() > 0 && operators.GetLast() != "(" {
-				output = append(output, operators.Pop())
+			reduceUntilParen()
+			var openParen = operators.Pop()
+			frames = frames[:len(frames)-1]
+			if frame.isCall() {
+				nodes = append(nodes, &CallNode{Function: frame.function.value, Args: popNodes(frame.argsCount),
+					Pos: Span{Start: frame.function.position, End: tokSpan.End}})
+			} else { // участок узла в скобках включает и сами скобки.
+				setSpan(nodes[len(nodes)-1], Span{Start: openParen.position, End: tokSpan.End})
 			}
-			if operators.Len() == 0 {
-				return nil, mismatchedParentheses
+		case tok.value == ",":
+			if len(frames) == 0 || !frames[len(frames)-1].isCall() {
+				return nil, newUnexpectedTokenError(tok, getExpectedOperator(frames), InvalidExpression)
 			}
-			firstMustBeOperator = true
-			operators.Pop()
-		} else if IsOperator(token) {
-			if firstMustBeOperator {
-				firstMustBeOperator = false
+			var frame = &frames[len(frames)-1]
+			frame.argsCount++
+			if arity, _ := GetFunctionArity(frame.function.value); arity.Max != 0 && frame.argsCount >= arity.Max {
+				return nil, newUnexpectedTokenError(tok, getExpectedArgs(frame.function.value, arity),
+					WrongArgumentsCount)
 			}
-			for operators.Len() > 0 && getPriority(operators.GetLast()) >= getPriority(token) {
-				output = append(output, operators.Pop())
+			reduceUntilParen()
+			expectOperand = true
+		case isBinaryOperator(tok.value):
+			if tok.value == PowerAlias {
+				tok.value = Power
 			}
-			operators.Push(token)
-			operatorCount++
-		} else {
-			return nil, errors.New("invalid operator/operand")
+			for operators.Len() > 0 && shouldPopBefore(operators.GetLast().value, tok.value) {
+				reduce()
+			}
+			operators.Push(tok)
+			expectOperand = true
+		default:
+			return nil, newUnexpectedTokenError(tok, getExpectedOperator(frames), InvalidExpression)
 		}
 	}
 
+	if expectOperand {
+		return nil, ParseError{Position: exprLen, Expected: expectedOperand, Err: InvalidExpression}
+	}
+	if len(frames) > 0 {
+		return nil, ParseError{Position: exprLen, Expected: "')'", Err: MismatchedParentheses}
+	}
 	for operators.Len() > 0 {
-		if operators.GetLast() == "(" {
-			return nil, mismatchedParentheses
-		}
-		output = append(output, operators.Pop())
+		reduce()
 	}
 
-	if operatorCount != operandCount-1 {
-		return nil, InvalidExpression
+	return nodes[0], nil
+}
+
+// shouldPopBefore сообщает, нужно ли вытолкнуть stackTop в выход перед тем, как положить в стек incoming.
+// Левоассоциативные операторы выталкивают операторы того же приоритета, правоассоциативные (^) — нет, поэтому
+// 2^3^2 = 2^(3^2).
+func shouldPopBefore(stackTop, incoming string) bool {
+	var topPriority, incomingPriority = getPriority(stackTop), getPriority(incoming)
+	if isRightAssociative(incoming) {
+		return topPriority > incomingPriority
 	}
+	return topPriority >= incomingPriority
+}
 
-	return output, nil
+func isRightAssociative(op string) bool {
+	return op == Power
 }
 
+// getPriority — приоритет оператора. Степень связывает сильнее унарного минуса: -2^2 = -(2^2), но 2^-1 = 0.5.
+// Сравнения связывают слабее арифметики, а логические операторы — слабее сравнений, как в C: a + 1 < b && c == d
+// = ((a + 1) < b) && (c == d). Побитовые операторы, в отличие от C, связывают сильнее сравнений, как в Python:
+// a & 1 == 1 = (a & 1) == 1; сдвиги — слабее сложения: 1 << n - 1 = 1 << (n - 1).
 func getPriority(op string) int {
 	switch op {
-	case "+", "-":
+	case LogicalOr:
 		return 1
-	case "*", "/":
+	case LogicalAnd:
 		return 2
+	case Equal, NotEqual:
+		return 3
+	case Less, LessEqual, Greater, GreaterEqual:
+		return 4
+	case BitwiseOr:
+		return 5
+	case BitwiseXor:
+		return 6
+	case BitwiseAnd:
+		return 7
+	case ShiftLeft, ShiftRight:
+		return 8
+	case "+", "-":
+		return 9
+	case "*", "/", Modulo, IntDivision:
+		return 10
+	case UnaryMinus, UnaryPlus, LogicalNot, BitwiseNot:
+		return 11
+	case Power:
+		return 12
 	default:
 		return 0
 	}
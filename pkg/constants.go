@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"math"
+	"slices"
+)
+
+// constants — встроенные именованные константы. В выражении они подставляются так же, как переменные, но значение
+// для них передавать не нужно.
+var constants = map[string]float64{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"tau": 2 * math.Pi,
+	"inf": math.Inf(1),
+}
+
+// ImaginaryUnit — мнимая единица. Она тоже встроенная константа, но комплексная, поэтому её нет в constants, а Bind
+// подставляет её отдельно.
+const ImaginaryUnit = "i"
+
+func GetConstant(name string) (value float64, ok bool) {
+	value, ok = constants[name]
+	return
+}
+
+func IsConstant(name string) bool {
+	_, ok := constants[name]
+	return ok || name == ImaginaryUnit
+}
+
+// ConstantNames возвращает имена всех встроенных констант в алфавитном порядке.
+func ConstantNames() (result []string) {
+	for name := range constants {
+		result = append(result, name)
+	}
+	result = append(result, ImaginaryUnit)
+	slices.Sort(result)
+	return
+}
@@ -1,10 +1,68 @@
 package pkg
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var NotImplementedError = errors.New("not implemented")
 
 var (
-	mismatchedParentheses = errors.New("mismatched parentheses")
+	MismatchedParentheses = errors.New("mismatched parentheses")
 	InvalidExpression     = errors.New("invalid expression")
+	UnknownFunction       = errors.New("unknown function")
+	WrongArgumentsCount   = errors.New("wrong number of function arguments")
+	UnboundVariable       = errors.New("unbound variable")
 )
+
+// expectedOperand — подсказка для места, где ожидается операнд. Её показывает клиенту фронтенд.
+const expectedOperand = "operand (number, identifier, function call, unary operator or '(')"
+
+// getExpectedOperator возвращает подсказку для места, где ожидается оператор: закрывающая скобка допустима только
+// при наличии открытых.
+func getExpectedOperator(frames []parenFrame) string {
+	switch {
+	case len(frames) == 0:
+		return "operator or end of expression"
+	case frames[len(frames)-1].isCall():
+		return "operator, ',' or ')'"
+	default:
+		return "operator or ')'"
+	}
+}
+
+func getExpectedNumber(err error) string {
+	if errors.Is(err, NumberOutOfRange) {
+		return "number within float64 range"
+	}
+	return "decimal, 0x, 0b or 0o number"
+}
+
+func getExpectedArgs(function string, arity Arity) string {
+	return fmt.Sprintf("%s to take %s", function, arity)
+}
+
+// ParseError описывает ошибку разбора выражения. Err — одна из ошибок выше (InvalidExpression,
+// MismatchedParentheses и т.д.), поэтому ошибку можно проверять через errors.Is.
+type ParseError struct {
+	Position int    // смещение проблемного токена в байтах; для неожиданного конца выражения — длина выражения.
+	Token    string // проблемный токен; пустой, если выражение закончилось раньше времени.
+	Expected string // что ожидалось на месте Token.
+	Err      error
+}
+
+func newUnexpectedTokenError(tok token, expected string, err error) ParseError {
+	return ParseError{Position: tok.position, Token: tok.value, Expected: expected, Err: err}
+}
+
+func (p ParseError) Error() string {
+	var found = "end of expression"
+	if p.Token != "" {
+		found = fmt.Sprintf("'%s'", p.Token)
+	}
+	return fmt.Sprintf("%s: unexpected %s at column %d, expected %s", p.Err, found, p.Position+1, p.Expected)
+}
+
+func (p ParseError) Unwrap() error {
+	return p.Err
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"math"
+	"strings"
+)
+
+// leafPriority — приоритет числа, переменной и вызова функции: их никогда не нужно брать в скобки.
+const leafPriority = 13
+
+// Format записывает дерево в каноническом инфиксном виде: бинарные операторы окружены пробелами, аргументы функций
+// разделены ", ", а скобки ставятся только там, где без них дерево разобралось бы иначе. Число, подставленное
+// вместо переменной, записывается её именем. Parse от результата возвращает дерево той же структуры.
+func Format(node Node) string {
+	var builder strings.Builder
+	writeNode(&builder, node)
+	return builder.String()
+}
+
+func writeNode(builder *strings.Builder, node Node) {
+	switch n := node.(type) {
+	case *NumberNode:
+		builder.WriteString(formatNumberNode(n))
+	case *VariableNode:
+		builder.WriteString(n.Name)
+	case *UnaryNode:
+		builder.WriteString(getUnarySymbol(n.Operator))
+		writeOperand(builder, n.Operand, getNodePriority(n.Operand) < getPriority(n.Operator))
+	case *BinaryNode:
+		var (
+			priority      = getPriority(n.Operator)
+			leftPriority  = getNodePriority(n.Left)
+			rightPriority = getNodePriority(n.Right)
+		)
+		writeOperand(builder, n.Left, leftPriority < priority ||
+			(leftPriority == priority && isRightAssociative(n.Operator)))
+		builder.WriteString(" " + n.Operator + " ")
+		// Унарный оператор справа скобок не требует: в позиции операнда он и так забирает всё, что связывает
+		// сильнее, — 2 ^ -1, a - -b.
+		writeOperand(builder, n.Right, !isUnaryNode(n.Right) && (rightPriority < priority ||
+			(rightPriority == priority && !isRightAssociative(n.Operator))))
+	case *CallNode:
+		builder.WriteString(n.Function + "(")
+		for ind, arg := range n.Args {
+			if ind > 0 {
+				builder.WriteString(", ")
+			}
+			writeNode(builder, arg)
+		}
+		builder.WriteString(")")
+	}
+}
+
+func writeOperand(builder *strings.Builder, node Node, parenthesize bool) {
+	if parenthesize {
+		builder.WriteString("(")
+	}
+	writeNode(builder, node)
+	if parenthesize {
+		builder.WriteString(")")
+	}
+}
+
+func formatNumberNode(n *NumberNode) string {
+	switch {
+	case n.Name != "":
+		return n.Name
+	case math.IsInf(n.Value, 1):
+		return "inf"
+	case math.IsInf(n.Value, -1):
+		return "-inf"
+	default:
+		return formatNumberValue(n)
+	}
+}
+
+// formatNumberValue записывает значение числа: Exact, если она задана, иначе FormatNumber(Value), а у мнимого числа —
+// FormatNumber(Imag) с ImaginarySuffix.
+func formatNumberValue(n *NumberNode) string {
+	switch {
+	case n.Imag != 0:
+		return FormatNumber(n.Imag) + ImaginarySuffix
+	case n.Exact != "":
+		return n.Exact
+	}
+	return FormatNumber(n.Value)
+}
+
+// getNodePriority — приоритет узла как операнда. Отрицательное число записывается с минусом, поэтому ведёт себя
+// как унарный минус: (-3) ^ 2, но -3 * 2.
+func getNodePriority(node Node) int {
+	switch n := node.(type) {
+	case *UnaryNode:
+		return getPriority(n.Operator)
+	case *BinaryNode:
+		return getPriority(n.Operator)
+	case *NumberNode:
+		if n.Name == "" && (math.Signbit(n.Value) || math.Signbit(n.Imag)) {
+			return getPriority(UnaryMinus)
+		}
+	}
+	return leafPriority
+}
+
+func isUnaryNode(node Node) bool {
+	return getNodePriority(node) == getPriority(UnaryMinus)
+}
+
+func getUnarySymbol(operator string) string {
+	switch operator {
+	case UnaryMinus:
+		return "-"
+	case UnaryPlus:
+		return "+"
+	}
+	return operator
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Arity — допустимое число аргументов функции. Max == 0 означает, что число аргументов не ограничено сверху.
+type Arity struct {
+	Min int
+	Max int
+}
+
+func (a Arity) Accepts(argsCount int) bool {
+	return argsCount >= a.Min && (a.Max == 0 || argsCount <= a.Max)
+}
+
+func (a Arity) String() string {
+	switch {
+	case a.Min == a.Max:
+		return fmt.Sprintf("%d %s", a.Min, getArgumentsWord(a.Min))
+	case a.Max == 0:
+		return fmt.Sprintf("at least %d %s", a.Min, getArgumentsWord(a.Min))
+	default:
+		return fmt.Sprintf("%d to %d arguments", a.Min, a.Max)
+	}
+}
+
+func getArgumentsWord(count int) string {
+	if count == 1 {
+		return "argument"
+	}
+	return "arguments"
+}
+
+// functions — встроенные функции, которые можно вызывать в выражении. Считает их агент.
+var functions = map[string]Arity{
+	"abs":   {1, 1},
+	"sqrt":  {1, 1},
+	"cbrt":  {1, 1},
+	"exp":   {1, 1},
+	"log":   {1, 2}, // log(x) — натуральный логарифм, log(x, base) — по основанию base.
+	"log2":  {1, 1},
+	"log10": {1, 1},
+	"sin":   {1, 1},
+	"cos":   {1, 1},
+	"tan":   {1, 1},
+	"asin":  {1, 1},
+	"acos":  {1, 1},
+	"atan":  {1, 1},
+	"atan2": {2, 2},
+	"round": {1, 1},
+	"floor": {1, 1},
+	"ceil":  {1, 1},
+	"trunc": {1, 1},
+	"min":   {1, 0},
+	"max":   {1, 0},
+	"arg":   {1, 1}, // arg, conj, re и im — только для комплексных чисел (см. Complex).
+	"conj":  {1, 1},
+	"re":    {1, 1},
+	"im":    {1, 1},
+	"if":    {3, 3}, // см. Conditional.
+}
+
+// Conditional — if(cond, a, b): a, если условие cond истинно, иначе b. Ветви a и b одного типа, и считается только
+// выбранная из них, поэтому if(x != 0, 1 / x, 0) не даёт деления на ноль.
+const Conditional = "if"
+
+// GetFunctionArity возвращает допустимое число аргументов встроенной функции name.
+func GetFunctionArity(name string) (arity Arity, ok bool) {
+	arity, ok = functions[name]
+	return
+}
+
+// FunctionNames возвращает имена всех встроенных функций в алфавитном порядке.
+func FunctionNames() (result []string) {
+	for name := range functions {
+		result = append(result, name)
+	}
+	slices.Sort(result)
+	return
+}
+
+// IsIdentifier сообщает, может ли token быть именем: латинская буква или _, за которыми идут буквы, цифры или _.
+func IsIdentifier(token string) bool {
+	if token == "" {
+		return false
+	}
+	for ind, char := range token {
+		var isLetter = char == '_' || (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z')
+		if !isLetter && (ind == 0 || char < '0' || char > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Вызов функции записывается в постфиксе одним токеном вида "имя/число аргументов", например "min/3": число
+// аргументов у min и max переменное, и без него нельзя понять, сколько операндов снять со стека.
+const functionCallSeparator = "/"
+
+func FunctionCallToken(name string, argsCount int) string {
+	return name + functionCallSeparator + strconv.Itoa(argsCount)
+}
+
+// ParseFunctionCall разбирает токен постфикса, созданный FunctionCallToken.
+func ParseFunctionCall(token string) (name string, argsCount int, ok bool) {
+	name, maybeCount, found := strings.Cut(token, functionCallSeparator)
+	if !found {
+		return
+	}
+	arity, known := functions[name]
+	if !known {
+		return
+	}
+	argsCount, err := strconv.Atoi(maybeCount)
+	if err != nil || !arity.Accepts(argsCount) {
+		return "", 0, false
+	}
+	return name, argsCount, true
+}
+
+func IsFunctionCall(token string) bool {
+	_, _, ok := ParseFunctionCall(token)
+	return ok
+}
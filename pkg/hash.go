@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"strconv"
+)
+
+// Hash — отпечаток поддерева. Он зависит только от операторов, функций и значений: у одинаковых поддеревьев, в
+// том числе из разных выражений, отпечатки совпадают, а позиции в исходном выражении и имена подставленных
+// переменных и констант не учитываются. Числа сравниваются по записи (FormatNumber или NumberNode.Exact), поэтому 0
+// и -0 различаются.
+type Hash [sha256.Size]byte
+
+// HashTree возвращает отпечатки всех поддеревьев node. Отпечаток узла строится из отпечатков его детей, поэтому
+// всё дерево обходится один раз.
+func HashTree(node Node) map[Node]Hash {
+	var result = make(map[Node]Hash)
+	hashNode(node, result)
+	return result
+}
+
+func hashNode(node Node, hashes map[Node]Hash) (result Hash) {
+	var (
+		hasher   = sha256.New()
+		children []Node
+	)
+	switch n := node.(type) {
+	case *NumberNode:
+		hasher.Write([]byte("number " + formatNumberValue(n)))
+	case *VariableNode:
+		hasher.Write([]byte("variable " + n.Name))
+	case *UnaryNode:
+		hasher.Write([]byte("unary " + n.Operator))
+		children = []Node{n.Operand}
+	case *BinaryNode:
+		hasher.Write([]byte("binary " + n.Operator))
+		children = []Node{n.Left, n.Right}
+	case *CallNode:
+		hasher.Write([]byte("call " + n.Function + " " + strconv.Itoa(len(n.Args))))
+		children = n.Args
+	}
+	for _, child := range children {
+		var childHash = hashNode(child, hashes)
+		hasher.Write(childHash[:])
+	}
+	hasher.Sum(result[:0])
+	hashes[node] = result
+	return
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	MalformedNumber  = errors.New("malformed number")
+	NumberOutOfRange = errors.New("number out of range")
+)
+
+// Числа в выражении записываются так:
+//
+//	десятичные: 42, 3.14, .5, 2., 1e-5, 6.02E+23
+//	с префиксом: 0x1F (16-ричные), 0b101 (двоичные), 0o17 (восьмеричные) — только целые
+//	мнимые: любое из них с суффиксом ImaginarySuffix — 4i, 2.5i, 1e-3i
+//
+// Цифры можно разделять одиночным _ (1_000_000, 0xFF_FF), но не в начале, не в конце и не рядом с точкой или
+// экспонентой. Буквы в префиксе, экспоненте и 16-ричных цифрах могут быть любого регистра.
+var numberPrefixes = map[string]int{"0x": 16, "0b": 2, "0o": 8}
+
+// ImaginarySuffix отмечает мнимое число: 3+4i — сумма 3 и мнимого 4i.
+const ImaginarySuffix = "i"
+
+// ParseNumber разбирает число из выражения или из постфикса. Помимо литералов выше допускается знак и бесконечность
+// ("-3", "+Inf"): так FormatNumber записывает в постфикс значения переменных и констант. Ошибка — MalformedNumber
+// или NumberOutOfRange.
+func ParseNumber(value string) (result float64, err error) {
+	var sign = 1.0
+	if value != "" && (value[0] == '+' || value[0] == '-') {
+		if value[0] == '-' {
+			sign = -1
+		}
+		value = value[1:]
+	}
+	if value == "Inf" {
+		return math.Inf(int(sign)), nil
+	}
+	if base, ok := numberPrefixes[strings.ToLower(value[:min(2, len(value))])]; ok {
+		result, err = parsePrefixedNumber(value[2:], base)
+	} else {
+		result, err = parseDecimalNumber(value)
+	}
+	return sign * result, err
+}
+
+// IsNumber сообщает, разберёт ли ParseNumber token.
+func IsNumber(token string) bool {
+	_, err := ParseNumber(token)
+	return err == nil
+}
+
+// IsNumberLiteral сообщает, разберёт ли parseNumberNode token: то же, что IsNumber, но и мнимые числа.
+func IsNumberLiteral(token string) bool {
+	_, err := parseNumberNode(token, Span{})
+	return err == nil
+}
+
+// parseNumberNode разбирает литерал числа, в том числе мнимого, в узел с участком pos.
+func parseNumberNode(literal string, pos Span) (*NumberNode, error) {
+	if digits, ok := strings.CutSuffix(literal, ImaginarySuffix); ok {
+		imag, err := ParseNumber(digits)
+		if err != nil {
+			return nil, err
+		}
+		return &NumberNode{Imag: imag, Pos: pos}, nil
+	}
+	value, err := ParseNumber(literal)
+	if err != nil {
+		return nil, err
+	}
+	return &NumberNode{Value: value, Exact: getExactText(literal, value), Pos: pos}, nil
+}
+
+func parsePrefixedNumber(digits string, base int) (float64, error) {
+	if !isDigitSequence(digits, base) {
+		return 0, MalformedNumber
+	}
+	var integer, _ = new(big.Int).SetString(strings.ReplaceAll(digits, "_", ""), base)
+	result, _ := new(big.Float).SetInt(integer).Float64()
+	if math.IsInf(result, 0) {
+		return 0, NumberOutOfRange
+	}
+	return result, nil
+}
+
+func parseDecimalNumber(value string) (float64, error) {
+	var mantissa, exponent, hasExponent = cutExponent(value)
+	if hasExponent {
+		if strings.HasPrefix(exponent, "+") || strings.HasPrefix(exponent, "-") {
+			exponent = exponent[1:]
+		}
+		if !isDigitSequence(exponent, 10) {
+			return 0, MalformedNumber
+		}
+	}
+	var integerPart, fractionPart, hasPoint = strings.Cut(mantissa, ".")
+	if integerPart == "" && fractionPart == "" {
+		return 0, MalformedNumber
+	}
+	if (integerPart != "" && !isDigitSequence(integerPart, 10)) ||
+		(hasPoint && fractionPart != "" && !isDigitSequence(fractionPart, 10)) {
+		return 0, MalformedNumber
+	}
+	result, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64)
+	if errors.Is(err, strconv.ErrRange) && math.IsInf(result, 0) {
+		return 0, NumberOutOfRange
+	}
+	if err != nil && !errors.Is(err, strconv.ErrRange) { // ErrRange без бесконечности — потеря точности у нуля.
+		return 0, MalformedNumber
+	}
+	return result, nil
+}
+
+func cutExponent(value string) (mantissa, exponent string, found bool) {
+	if ind := strings.IndexAny(value, "eE"); ind >= 0 {
+		return value[:ind], value[ind+1:], true
+	}
+	return value, "", false
+}
+
+// isDigitSequence проверяет, что digits — непустая последовательность цифр системы base, в которой _ стоит только
+// между цифрами.
+func isDigitSequence(digits string, base int) bool {
+	if digits == "" || digits[0] == '_' || digits[len(digits)-1] == '_' || strings.Contains(digits, "__") {
+		return false
+	}
+	for _, char := range digits {
+		if char != '_' && getDigitValue(char) >= base {
+			return false
+		}
+	}
+	return true
+}
+
+// getDigitValue возвращает значение цифры char в 16-ричной системе или 16, если char — не цифра.
+func getDigitValue(char rune) int {
+	switch {
+	case char >= '0' && char <= '9':
+		return int(char - '0')
+	case char >= 'a' && char <= 'f':
+		return int(char-'a') + 10
+	case char >= 'A' && char <= 'F':
+		return int(char-'A') + 10
+	default:
+		return 16
+	}
+}
+
+// scanNumber возвращает конец числа, которое начинается в expr с позиции start (с цифры или точки). Сканирование
+// жадное: захватываются буквы, цифры, _ и точки, поэтому опечатка вроде 0b102 или 1.2.3 становится одним
+// некорректным числом, а не несколькими токенами. Знак входит в число только сразу после e или E десятичного числа.
+func scanNumber(expr string, start int) (end int) {
+	var prefixed = len(expr)-start > 2 && numberPrefixes[strings.ToLower(expr[start:start+2])] != 0
+	for end = start; end < len(expr); end++ {
+		var char = expr[end]
+		switch {
+		case char == '_' || char == '.' || (char >= '0' && char <= '9') || (char >= 'a' && char <= 'z') ||
+			(char >= 'A' && char <= 'Z'):
+		case (char == '+' || char == '-') && !prefixed && (expr[end-1] == 'e' || expr[end-1] == 'E'):
+		default:
+			return
+		}
+	}
+	return
+}
+
+func isNumberStart(char rune) bool {
+	return char == '.' || (char >= '0' && char <= '9')
+}
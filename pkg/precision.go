@@ -0,0 +1,341 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Precision — режим точности, в котором считается выражение.
+//
+//   - Float64 — числа float64, как и раньше. Аргументы задач передаются числами JSON.
+//   - Int64 — только целые в пределах int64; выход за них даёт ошибку overflow, а не тихое переполнение. Деление /
+//     должно быть нацело, иначе — domain_error; для деления с округлением вниз есть //.
+//   - Big — точные рациональные числа math/big: 0.1 + 0.2 = 0.3. Корень, которого нет среди рациональных, считается
+//     big.Float с точностью Bits двоичных разрядов.
+//   - Rational — те же рациональные числа, но без приближений: операции, результат которых может быть
+//     иррациональным (sqrt), недоступны, а результат выражения показывается и несократимой дробью.
+//   - Complex — числа complex128. Выражение с мнимыми числами или функциями arg, conj, re, im считается в нём, даже
+//     если режим не указан (см. RequiresComplex). Операций, которым нужен порядок (%, //, min, round и т. п.), нет.
+//     Аргументы и результаты задач передаются парами backend.Complex.
+//
+// Сравнения, логические операторы и Conditional есть только в Float64: логические значения в задачах других режимов
+// не передаются. Побитовые операторы есть во всех режимах, кроме Complex; в Float64 целые больше 2^53 float64
+// передаёт неточно: число-операнд больше 2^53 отклоняет CheckPrecision, а посчитанный операнд от 2^53 и
+// результат, который float64 не передаёт, — переполнение при вычислении. Для больших целых есть Int64 и Big.
+//
+// В точных режимах значения хранятся строками: в Int64 — целым числом ("-42"), в Big и Rational — несократимой дробью
+// big.Rat.RatString ("1/3", "5"). Агентам в Rational они передаются парами числитель/знаменатель (backend.Fraction).
+type Precision string
+
+const (
+	Float64  Precision = "float64"
+	Int64    Precision = "int64"
+	Big      Precision = "big"
+	Rational Precision = "rational"
+	Complex  Precision = "complex"
+)
+
+// Точность big.Float в режиме Big: по умолчанию и допустимые пределы.
+const (
+	DefaultBits uint = 256
+	MinBits     uint = 64
+	MaxBits     uint = 4096
+)
+
+var (
+	UnsupportedPrecision = errors.New("unsupported precision")
+	UnsupportedOperation = errors.New("operation is not supported in this precision")
+	NotAnInteger         = errors.New("not an integer")
+)
+
+// maxSafeInteger — 2^53: целые до него по модулю float64 передаёт точно.
+const maxSafeInteger = 1 << 53
+
+// exactFunctions — функции, которые можно считать в точных режимах, и режимы, в которых они доступны.
+var exactFunctions = map[string][]Precision{
+	"abs": {Int64, Big, Rational}, "min": {Int64, Big, Rational}, "max": {Int64, Big, Rational},
+	"floor": {Int64, Big, Rational}, "ceil": {Int64, Big, Rational}, "round": {Int64, Big, Rational},
+	"trunc": {Int64, Big, Rational}, "sqrt": {Big},
+}
+
+// complexFunctions — функции, которые можно считать в режиме Complex. Значение true означает, что функция есть только
+// в нём.
+var complexFunctions = map[string]bool{
+	"abs": false, "sqrt": false, "exp": false, "log": false, "log2": false, "log10": false, "sin": false,
+	"cos": false, "tan": false, "asin": false, "acos": false, "atan": false, "arg": true, "conj": true, "re": true,
+	"im": true,
+}
+
+// ParsePrecision разбирает режим из запроса. Пустая строка означает Float64.
+func ParsePrecision(value string) (Precision, error) {
+	switch Precision(value) {
+	case "", Float64:
+		return Float64, nil
+	case Int64, Big, Rational, Complex:
+		return Precision(value), nil
+	}
+	return "", UnsupportedPrecision
+}
+
+// IsExact сообщает, что значения в режиме передаются строками, а не float64. Пустой режим — Float64.
+func (p Precision) IsExact() bool {
+	return p == Int64 || p == Big || p == Rational
+}
+
+// IsFloat64 сообщает, что значения в режиме — float64. Пустой режим — Float64.
+func (p Precision) IsFloat64() bool {
+	return p == "" || p == Float64
+}
+
+// SupportsOperation сообщает, можно ли посчитать оператор или функцию operation в режиме p.
+func (p Precision) SupportsOperation(operation string) bool {
+	switch {
+	case IsLogicalOperation(operation):
+		return p.IsFloat64()
+	case IsBitwise(operation):
+		return p != Complex
+	case IsUnaryOperator(operation):
+		return true
+	case IsOperator(operation):
+		return p != Complex || (operation != Modulo && operation != IntDivision)
+	case p == Complex:
+		_, ok := complexFunctions[operation]
+		return ok
+	case complexFunctions[operation]:
+		return false
+	case p.IsFloat64():
+		return true
+	}
+	return slices.Contains(exactFunctions[operation], p)
+}
+
+// RequiresComplex сообщает, что в дереве есть мнимые числа или функции, которые есть только в режиме Complex.
+func RequiresComplex(node Node) bool {
+	switch n := node.(type) {
+	case *NumberNode:
+		return n.Imag != 0
+	case *UnaryNode:
+		return RequiresComplex(n.Operand)
+	case *BinaryNode:
+		return RequiresComplex(n.Left) || RequiresComplex(n.Right)
+	case *CallNode:
+		return complexFunctions[n.Function] || slices.ContainsFunc(n.Args, RequiresComplex)
+	}
+	return false
+}
+
+// EncodeNumber возвращает точную запись числа узла в режиме p (см. Precision). В Int64 число должно быть целым в
+// пределах int64, иначе — NotAnInteger или NumberOutOfRange; бесконечность в точных режимах недопустима.
+func (p Precision) EncodeNumber(n *NumberNode) (string, error) {
+	var value, err = GetExactValue(n)
+	if err != nil {
+		return "", err
+	}
+	if p == Int64 {
+		if !value.IsInt() {
+			return "", NotAnInteger
+		}
+		if !value.Num().IsInt64() {
+			return "", NumberOutOfRange
+		}
+		return value.Num().String(), nil
+	}
+	return value.RatString(), nil
+}
+
+// GetExactValue возвращает точное значение числа: Exact, если оно задано, иначе кратчайшую десятичную запись Value
+// (для 0.1 — ровно 1/10, а не ближайшее к нему float64).
+func GetExactValue(n *NumberNode) (*big.Rat, error) {
+	if math.IsInf(n.Value, 0) {
+		return nil, NumberOutOfRange
+	}
+	var text = n.Exact
+	if text == "" {
+		text = FormatNumber(n.Value)
+	}
+	return ParseExactNumber(text)
+}
+
+// ParseExactNumber разбирает литерал числа так же, как ParseNumber, но без потери точности.
+func ParseExactNumber(value string) (*big.Rat, error) {
+	if _, err := ParseNumber(value); err != nil && !errors.Is(err, NumberOutOfRange) {
+		return nil, err
+	}
+	var sign, digits = "", value
+	if digits != "" && (digits[0] == '+' || digits[0] == '-') {
+		sign, digits = digits[:1], digits[1:]
+	}
+	digits = strings.ReplaceAll(digits, "_", "")
+	if base, ok := numberPrefixes[strings.ToLower(digits[:min(2, len(digits))])]; ok {
+		var integer, _ = new(big.Int).SetString(sign+digits[2:], base)
+		return new(big.Rat).SetInt(integer), nil
+	}
+	var result, ok = new(big.Rat).SetString(sign + digits)
+	if !ok {
+		return nil, MalformedNumber
+	}
+	return result, nil
+}
+
+// getExactText возвращает значение для NumberNode.Exact: десятичную запись литерала, если FormatNumber(value) её не
+// передаёт, или пустую строку.
+func getExactText(literal string, value float64) string {
+	var exact, err = ParseExactNumber(literal)
+	if err != nil {
+		return ""
+	}
+	if rounded, err := ParseExactNumber(FormatNumber(value)); err == nil && rounded.Cmp(exact) == 0 {
+		return ""
+	}
+	if exact.IsInt() {
+		return exact.Num().String()
+	}
+	return strings.ReplaceAll(strings.TrimLeft(literal, "+"), "_", "")
+}
+
+// CheckPrecision проверяет, что дерево без переменных (после Bind) можно посчитать в режиме p: все операции
+// поддерживаются, а числа представимы. Ошибка — ParseError с позицией первого проблемного узла и Err
+// UnsupportedOperation, NotAnInteger или NumberOutOfRange. Показатель степени в Big и Rational должен быть целым по
+// типу (см. CheckTypes): 2^0.5 иррационально, а агент считает степень только точно. Операнды-числа побитовых операторов в Float64 должны быть
+// не больше 2^53 по модулю: float64 округляет 0xFFFFFFFFFFFFFFFF до 2^64, и & 0xFF дал бы 0.
+func CheckPrecision(node Node, p Precision) error {
+	var (
+		operation string
+		children  []Node
+	)
+	switch n := node.(type) {
+	case *NumberNode:
+		return checkNumberPrecision(n, p)
+	case *UnaryNode:
+		operation, children = n.Operator, []Node{n.Operand}
+	case *BinaryNode:
+		operation, children = n.Operator, []Node{n.Left, n.Right}
+	case *CallNode:
+		operation, children = n.Function, n.Args
+	}
+	if !p.SupportsOperation(operation) {
+		return ParseError{Position: node.Span().Start, Token: operation,
+			Expected: fmt.Sprintf("operation supported in %s precision", p), Err: UnsupportedOperation}
+	}
+	if n, ok := node.(*BinaryNode); ok && n.Operator == Power && (p == Big || p == Rational) {
+		if exponentType, _ := CheckTypes(n.Right); exponentType != IntegerType {
+			return ParseError{Position: n.Right.Span().Start, Token: Format(n.Right),
+				Expected: fmt.Sprintf("integer exponent in %s precision", p), Err: UnsupportedOperation}
+		}
+	}
+	for _, child := range children {
+		if number, ok := child.(*NumberNode); ok && p.IsFloat64() && IsBitwise(operation) && !isSafeInteger(number) {
+			return ParseError{Position: number.Pos.Start, Token: formatNumberNode(number),
+				Expected: "integer within ±2^53 in float64 precision", Err: NotAnInteger}
+		}
+		if err := CheckPrecision(child, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSafeInteger сообщает, что float64 передаёт число узла точно, а любое целое рядом с ним отличимо от соседних:
+// |значение| не больше 2^53. У числа с Exact float64 — лишь приближение литерала.
+func isSafeInteger(n *NumberNode) bool {
+	return n.Exact == "" && math.Abs(n.Value) <= maxSafeInteger
+}
+
+// NormalizeExact проверяет, что value — запись числа режима p, и возвращает её в том виде, в каком значения хранятся
+// в задачах: "2/4" становится "1/2".
+func NormalizeExact(p Precision, value string) (string, error) {
+	if p == Int64 {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", MalformedNumber
+		}
+		return value, nil
+	}
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return "", MalformedNumber
+	}
+	return rat.RatString(), nil
+}
+
+func checkNumberPrecision(n *NumberNode, p Precision) error {
+	var newError = func(expected string, err error) error {
+		return ParseError{Position: n.Pos.Start, Token: formatNumberNode(n), Expected: expected, Err: err}
+	}
+	switch {
+	case n.Imag != 0 && p != Complex:
+		return newError(fmt.Sprintf("real number in %s precision", p), UnsupportedOperation)
+	case p == Complex && math.IsInf(n.Value, 0):
+		return newError("finite number", NumberOutOfRange)
+	case p.IsExact():
+		if _, err := p.EncodeNumber(n); err != nil {
+			var expected = "integer within int64 range"
+			if p != Int64 {
+				expected = "finite number"
+			}
+			return newError(expected, err)
+		}
+	}
+	return nil
+}
+
+// FormatExact переводит точный результат value режима p в десятичную запись для клиента и приближённое float64.
+// Дробь, у которой нет конечной десятичной записи (1/3), записывается с точностью, соответствующей bits двоичным
+// разрядам (0 — DefaultBits).
+func FormatExact(p Precision, bits uint, value string) (text string, approx float64, err error) {
+	if p == Int64 {
+		integer, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", 0, MalformedNumber
+		}
+		return value, float64(integer), nil
+	}
+	rat, ok := new(big.Rat).SetString(value)
+	if !ok {
+		return "", 0, MalformedNumber
+	}
+	approx, _ = rat.Float64()
+	if rat.IsInt() {
+		return rat.Num().String(), approx, nil
+	}
+	var digits, terminating = getDecimalDigits(rat.Denom())
+	if !terminating {
+		if bits == 0 {
+			bits = DefaultBits
+		}
+		digits = int(float64(bits) * math.Log10(2))
+	}
+	text = rat.FloatString(digits)
+	if !terminating {
+		text = strings.TrimRight(strings.TrimRight(text, "0"), ".")
+	}
+	return text, approx, nil
+}
+
+// getDecimalDigits возвращает число знаков после точки в десятичной записи дроби со знаменателем denom, если она
+// конечна, то есть denom = 2^a * 5^b.
+func getDecimalDigits(denom *big.Int) (digits int, terminating bool) {
+	var (
+		rest         = new(big.Int).Set(denom)
+		twos, fives  int
+		remainder    = new(big.Int)
+		two, five    = big.NewInt(2), big.NewInt(5)
+		quotient     = new(big.Int)
+		divideOutAll = func(divisor *big.Int) (count int) {
+			for {
+				quotient.QuoRem(rest, divisor, remainder)
+				if remainder.Sign() != 0 {
+					return
+				}
+				rest.Set(quotient)
+				count++
+			}
+		}
+	)
+	twos, fives = divideOutAll(two), divideOutAll(five)
+	return max(twos, fives), rest.Cmp(big.NewInt(1)) == 0
+}
@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"math"
+)
+
+// FoldLimit — сколько операторов может быть в поддереве, чтобы Simplify посчитал его сразу, не отправляя агенту.
+// Больше поддеревья остаются агентам: ради них сервис и существует.
+const FoldLimit = 3
+
+// foldableOperators — операторы, которые Simplify считает сам. Все они дешёвые и дают ровно тот же результат, что
+// агент.
+var foldableOperators = map[string]bool{"+": true, "-": true, "*": true, "/": true, UnaryMinus: true,
+	UnaryPlus: true}
+
+// Simplify возвращает упрощённую копию дерева без переменных (после Bind) и число операторов, которые больше не
+// нужно считать. Упрощения не меняют результат (кроме, возможно, знака нуля) и не скрывают ошибок вычисления:
+//
+//   - поддерево из не более чем FoldLimit операторов +, -, *, / и унарных считается сразу, если при этом не
+//     возникает деления на ноль или переполнения — иначе ошибку, как и раньше, сообщит агент;
+//   - нейтральные элементы убираются: x + 0, 0 + x, x - 0, x * 1, 1 * x, x / 1, x ^ 1 и +x становятся x;
+//   - поглощающие элементы: 0 * x и x * 0 становятся 0, x ^ 0 — 1, но только если x заведомо конечно (см.
+//     getMagnitudeBound), иначе отброшенное поддерево могло бы завершиться ошибкой.
+//
+// Оценки и свёртка опираются на float64, поэтому в остальных режимах precision (см. Precision.IsFloat64) убираются
+// только нейтральные элементы.
+func Simplify(node Node, precision Precision) (result Node, saved int) {
+	result, _ = simplify(node, precision.IsFloat64())
+	return result, countOperations(node) - countOperations(result)
+}
+
+// simplify возвращает упрощённый узел и число операторов в исходном поддереве. fold разрешает свёртку и поглощающие
+// элементы.
+func simplify(node Node, fold bool) (Node, int) {
+	switch n := node.(type) {
+	case *UnaryNode:
+		operand, operations := simplify(n.Operand, fold)
+		operations++
+		var result Node = &UnaryNode{Operator: n.Operator, Operand: operand, Pos: n.Pos}
+		if n.Operator == UnaryPlus {
+			result = operand
+		}
+		return foldIfTiny(result, n.Pos, operations, fold), operations
+	case *BinaryNode:
+		left, leftOperations := simplify(n.Left, fold)
+		right, rightOperations := simplify(n.Right, fold)
+		var operations = leftOperations + rightOperations + 1
+		var result = simplifyBinary(&BinaryNode{Operator: n.Operator, Left: left, Right: right, Pos: n.Pos}, fold)
+		return foldIfTiny(result, n.Pos, operations, fold), operations
+	case *CallNode:
+		var (
+			args       = make([]Node, len(n.Args))
+			operations = 1
+		)
+		for ind, arg := range n.Args {
+			var argOperations int
+			args[ind], argOperations = simplify(arg, fold)
+			operations += argOperations
+		}
+		return &CallNode{Function: n.Function, Args: args, Pos: n.Pos}, operations
+	default:
+		return node, 0
+	}
+}
+
+func simplifyBinary(n *BinaryNode, fold bool) Node {
+	var leftIs, rightIs = isNumberEqual(n.Left), isNumberEqual(n.Right)
+	switch n.Operator {
+	case "+":
+		if rightIs(0) {
+			return n.Left
+		}
+		if leftIs(0) {
+			return n.Right
+		}
+	case "-":
+		if rightIs(0) {
+			return n.Left
+		}
+	case "*":
+		if rightIs(1) {
+			return n.Left
+		}
+		if leftIs(1) {
+			return n.Right
+		}
+		if fold && ((leftIs(0) && isBounded(n.Right)) || (rightIs(0) && isBounded(n.Left))) {
+			return &NumberNode{Value: 0, Pos: n.Pos}
+		}
+	case "/":
+		if rightIs(1) {
+			return n.Left
+		}
+	case Power:
+		if rightIs(1) {
+			return n.Left
+		}
+		if fold && rightIs(0) && isBounded(n.Left) {
+			return &NumberNode{Value: 1, Pos: n.Pos}
+		}
+	}
+	return n
+}
+
+// foldIfTiny считает узел, если его операнды уже числа, а исходное поддерево не больше FoldLimit операторов.
+func foldIfTiny(node Node, pos Span, operations int, fold bool) Node {
+	if !fold || operations > FoldLimit {
+		return node
+	}
+	var (
+		value float64
+		ok    bool
+	)
+	switch n := node.(type) {
+	case *UnaryNode:
+		operand, isNumber := n.Operand.(*NumberNode)
+		if !isNumber || !foldableOperators[n.Operator] {
+			return node
+		}
+		value, ok = -operand.Value, n.Operator == UnaryMinus
+	case *BinaryNode:
+		left, leftIsNumber := n.Left.(*NumberNode)
+		right, rightIsNumber := n.Right.(*NumberNode)
+		if !leftIsNumber || !rightIsNumber || !foldableOperators[n.Operator] {
+			return node
+		}
+		value, ok = foldBinary(n.Operator, left.Value, right.Value)
+	default:
+		return node
+	}
+	if !ok || math.IsInf(value, 0) || math.IsNaN(value) {
+		return node
+	}
+	return &NumberNode{Value: value, Pos: pos}
+}
+
+func foldBinary(operator string, left, right float64) (result float64, ok bool) {
+	switch operator {
+	case "+":
+		return left + right, true
+	case "-":
+		return left - right, true
+	case "*":
+		return left * right, true
+	case "/":
+		return left / right, right != 0
+	}
+	return 0, false
+}
+
+// isNumberEqual сравнивает вещественное число узла с value. Число с Exact float64 передаёт неточно
+// (1.00000000000000000001 — не 1), поэтому оно не равно ничему.
+func isNumberEqual(node Node) func(value float64) bool {
+	return func(value float64) bool {
+		number, ok := node.(*NumberNode)
+		return ok && number.Exact == "" && number.Imag == 0 && number.Value == value
+	}
+}
+
+func isBounded(node Node) bool {
+	_, ok := getMagnitudeBound(node)
+	return ok
+}
+
+// getMagnitudeBound оценивает сверху модуль значения поддерева. ok == true, только если оценка конечна, а значит,
+// поддерево заведомо посчитается без ошибок: округление монотонно, поэтому ни один промежуточный результат не
+// превысит оценку. Для операций, которые могут завершиться ошибкой (деление, степень, большинство функций), оценки
+// нет.
+func getMagnitudeBound(node Node) (bound float64, ok bool) {
+	switch n := node.(type) {
+	case *NumberNode:
+		bound = math.Abs(n.Value)
+	case *UnaryNode:
+		bound, ok = getMagnitudeBound(n.Operand)
+	case *BinaryNode:
+		left, leftOk := getMagnitudeBound(n.Left)
+		right, rightOk := getMagnitudeBound(n.Right)
+		if !leftOk || !rightOk {
+			return 0, false
+		}
+		switch n.Operator {
+		case "+", "-":
+			bound = left + right
+		case "*":
+			bound = left * right
+		default:
+			return 0, false
+		}
+	case *CallNode:
+		var argsBound float64
+		for _, arg := range n.Args {
+			argBound, argOk := getMagnitudeBound(arg)
+			if !argOk {
+				return 0, false
+			}
+			argsBound = max(argsBound, argBound)
+		}
+		switch n.Function {
+		case "sin", "cos":
+			bound = 1
+		case "atan":
+			bound = math.Pi / 2
+		case "abs", "min", "max", "trunc":
+			bound = argsBound
+		case "floor", "ceil", "round":
+			bound = argsBound + 1
+		default:
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	return bound, !math.IsInf(bound, 0) && !math.IsNaN(bound)
+}
+
+// countOperations — число операторов и вызовов функций в дереве, то есть число задач, на которые оно разобьётся.
+func countOperations(node Node) (result int) {
+	switch n := node.(type) {
+	case *UnaryNode:
+		return 1 + countOperations(n.Operand)
+	case *BinaryNode:
+		return 1 + countOperations(n.Left) + countOperations(n.Right)
+	case *CallNode:
+		result = 1
+		for _, arg := range n.Args {
+			result += countOperations(arg)
+		}
+	}
+	return
+}
@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"errors"
+	"math"
+)
+
+// ValueType — тип значения узла. Логические значения дают только сравнения и логические операторы, а принимают —
+// логические операторы, == и != и условие Conditional; всё остальное работает с числами. IntegerType — частный
+// случай числа: целые нужны побитовым операторам (см. IsBitwise), а подходят они везде, где ожидается число.
+type ValueType int
+
+const (
+	NumberType ValueType = iota
+	BooleanType
+	IntegerType
+)
+
+func (v ValueType) String() string {
+	switch v {
+	case BooleanType:
+		return "boolean"
+	case IntegerType:
+		return "integer"
+	}
+	return "number"
+}
+
+// accepts сообщает, что значение типа actual подходит туда, где ожидается v.
+func (v ValueType) accepts(actual ValueType) bool {
+	return v == actual || (v == NumberType && actual == IntegerType)
+}
+
+var TypeMismatch = errors.New("type mismatch")
+
+// integerFunctions — функции, результат которых целый при любых аргументах.
+var integerFunctions = map[string]bool{"floor": true, "ceil": true, "round": true, "trunc": true}
+
+// CheckTypes проверяет, что у каждого оператора и функции операнды нужного типа, и возвращает тип значения всего
+// дерева. Число целое, если целое его значение; +, -, *, %, //, abs, min и max от целых — целые, / и ^ — нет.
+// Значения переменных до Bind неизвестны, поэтому переменные считаются целыми, а Bind проверяет типы ещё раз.
+// Константы после Bind — числа. Ошибка — ParseError с TypeMismatch и позицией первого операнда не того типа; Token у
+// неё — канонический вид операнда (см. Format).
+func CheckTypes(node Node) (result ValueType, err error) {
+	switch n := node.(type) {
+	case *NumberNode:
+		return getNumberType(n), nil
+	case *VariableNode:
+		return IntegerType, nil
+	case *UnaryNode:
+		switch n.Operator {
+		case LogicalNot:
+			return BooleanType, checkType(n.Operand, BooleanType)
+		case BitwiseNot:
+			return IntegerType, checkType(n.Operand, IntegerType)
+		}
+		return expectType(n.Operand, NumberType)
+	case *BinaryNode:
+		return checkBinaryTypes(n)
+	case *CallNode:
+		if n.Function == Conditional {
+			if err = checkType(n.Args[0], BooleanType); err != nil {
+				return
+			}
+			return checkSameTypes(n.Args[1], n.Args[2])
+		}
+		result = IntegerType
+		for _, arg := range n.Args {
+			argType, err := expectType(arg, NumberType)
+			if err != nil {
+				return result, err
+			}
+			result = joinNumbers(result, argType)
+		}
+		switch {
+		case integerFunctions[n.Function]:
+			return IntegerType, nil
+		case n.Function == "abs" || n.Function == "min" || n.Function == "max":
+			return result, nil
+		}
+	}
+	return NumberType, nil
+}
+
+func checkBinaryTypes(n *BinaryNode) (result ValueType, err error) {
+	var operandsType = NumberType
+	switch {
+	case n.Operator == Equal || n.Operator == NotEqual:
+		_, err = checkSameTypes(n.Left, n.Right)
+		return BooleanType, err
+	case n.Operator == LogicalAnd || n.Operator == LogicalOr:
+		operandsType = BooleanType
+	case IsBitwise(n.Operator):
+		operandsType = IntegerType
+	}
+	left, err := expectType(n.Left, operandsType)
+	if err != nil {
+		return
+	}
+	right, err := expectType(n.Right, operandsType)
+	if err != nil {
+		return
+	}
+	switch {
+	case IsComparison(n.Operator):
+		return BooleanType, nil
+	case n.Operator == "/" || n.Operator == Power:
+		return NumberType, nil
+	}
+	return joinNumbers(left, right), nil
+}
+
+// checkSameTypes проверяет, что right того же типа, что и left (целое и нецелое число — один тип), и возвращает
+// общий тип.
+func checkSameTypes(left, right Node) (result ValueType, err error) {
+	if result, err = CheckTypes(left); err != nil {
+		return
+	}
+	var expected = result
+	if expected == IntegerType {
+		expected = NumberType
+	}
+	rightType, err := expectType(right, expected)
+	return joinNumbers(result, rightType), err
+}
+
+// joinNumbers — тип результата арифметики над значениями типов left и right: целый, только если оба целые.
+func joinNumbers(left, right ValueType) ValueType {
+	if left == IntegerType {
+		return right
+	}
+	return left
+}
+
+// expectType проверяет, что node подходит туда, где ожидается expected, и возвращает его тип.
+func expectType(node Node, expected ValueType) (actual ValueType, err error) {
+	if actual, err = CheckTypes(node); err != nil {
+		return
+	}
+	if !expected.accepts(actual) {
+		return actual, ParseError{Position: node.Span().Start, Token: Format(node), Expected: expected.String(),
+			Err: TypeMismatch}
+	}
+	return
+}
+
+func checkType(node Node, expected ValueType) error {
+	var _, err = expectType(node, expected)
+	return err
+}
+
+func getNumberType(n *NumberNode) ValueType {
+	if n.Imag != 0 || math.IsInf(n.Value, 0) {
+		return NumberType
+	}
+	if n.Exact != "" {
+		if exact, err := ParseExactNumber(n.Exact); err != nil || !exact.IsInt() {
+			return NumberType
+		}
+		return IntegerType
+	}
+	if n.Value != math.Trunc(n.Value) {
+		return NumberType
+	}
+	return IntegerType
+}
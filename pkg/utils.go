@@ -2,19 +2,87 @@ package pkg
 
 import (
 	"math"
-	"strconv"
 	"sync"
 )
 
-func IsNumber(token string) bool {
-	if _, err := strconv.ParseFloat(token, 64); err == nil {
-		return true
-	}
-	return false
-}
+// Унарные операторы в постфиксе записываются отдельными токенами, чтобы не путать их с бинарными + и -.
+const (
+	UnaryMinus = "neg"
+	UnaryPlus  = "pos"
+)
+
+// Power — оператор возведения в степень. В выражении его можно записать и как PowerAlias, но в постфикс всегда
+// попадает Power.
+const (
+	Power      = "^"
+	PowerAlias = "**"
+)
+
+// Modulo — остаток от деления, IntDivision — деление с округлением вниз. Оба согласованы между собой:
+// a = (a // b) * b + a % b, поэтому знак остатка совпадает со знаком делителя: -7 % 3 = 2, -7 // 3 = -3.
+const (
+	Modulo      = "%"
+	IntDivision = "//"
+)
+
+// Операторы сравнения и логические операторы. Их результат — логическое значение (см. ValueType), а не число.
+// LogicalNot унарный, но, в отличие от UnaryMinus и UnaryPlus, записывается в постфикс тем же символом, что и в
+// выражении.
+const (
+	Less         = "<"
+	LessEqual    = "<="
+	Greater      = ">"
+	GreaterEqual = ">="
+	Equal        = "=="
+	NotEqual     = "!="
+	LogicalAnd   = "&&"
+	LogicalOr    = "||"
+	LogicalNot   = "!"
+)
+
+// Побитовые операторы. Их операнды и результат — целые числа (см. IntegerType). BitwiseXor записывается словом, потому
+// что ^ — это Power; BitwiseNot, как и LogicalNot, записывается в постфикс тем же символом.
+const (
+	BitwiseAnd = "&"
+	BitwiseOr  = "|"
+	BitwiseXor = "xor"
+	BitwiseNot = "~"
+	ShiftLeft  = "<<"
+	ShiftRight = ">>"
+)
 
 func IsOperator(token string) bool {
-	return token == "+" || token == "-" || token == "*" || token == "/"
+	return token == "+" || token == "-" || token == "*" || token == "/" || token == Power || token == Modulo ||
+		token == IntDivision || IsComparison(token) || token == LogicalAnd || token == LogicalOr ||
+		(IsBitwise(token) && token != BitwiseNot)
+}
+
+func IsUnaryOperator(token string) bool {
+	return token == UnaryMinus || token == UnaryPlus || token == LogicalNot || token == BitwiseNot
+}
+
+// IsBitwise сообщает, что operation — побитовый оператор или сдвиг.
+func IsBitwise(operation string) bool {
+	return operation == BitwiseAnd || operation == BitwiseOr || operation == BitwiseXor || operation == BitwiseNot ||
+		operation == ShiftLeft || operation == ShiftRight
+}
+
+// IsComparison сообщает, что token — оператор сравнения.
+func IsComparison(token string) bool {
+	return token == Less || token == LessEqual || token == Greater || token == GreaterEqual || token == Equal ||
+		token == NotEqual
+}
+
+// IsLogicalOperation сообщает, что operation — оператор сравнения, логический оператор или Conditional: всё, что
+// работает с логическими значениями.
+func IsLogicalOperation(operation string) bool {
+	return IsComparison(operation) || operation == LogicalAnd || operation == LogicalOr || operation == LogicalNot ||
+		operation == Conditional
+}
+
+// ReturnsBoolean сообщает, что результат оператора operation — логическое значение.
+func ReturnsBoolean(operation string) bool {
+	return IsComparison(operation) || operation == LogicalAnd || operation == LogicalOr || operation == LogicalNot
 }
 
 type Stack[T any] struct {